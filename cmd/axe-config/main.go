@@ -0,0 +1,77 @@
+// axe-config checks the rpc.toml files of the servers, e.g. in CI before deploying:
+//
+//	axe-config check rpc.toml
+//
+// It loads the files like NewServer, with the overlay of the env and the environment variables, sets the default values
+// and prints every problem found by Config.Validate with its toml path, the exit status is 1 when any file is invalid.
+//
+// The effective config is printed with the secrets redacted by:
+//
+//	AXE_ENV=prod axe-config dump rpc.toml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fengbeihong/rpc-go/rpc"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: axe-config check <rpc.toml>...\n       axe-config dump <rpc.toml>\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "check":
+		if !checkAll(flag.Args()[1:]) {
+			os.Exit(1)
+		}
+	case "dump":
+		if err := dump(flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(1), err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func checkAll(paths []string) bool {
+	ok := true
+	for _, path := range paths {
+		if err := check(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	return ok
+}
+
+func check(path string) error {
+	cfg, err := rpc.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+func dump(path string) error {
+	cfg, err := rpc.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	return cfg.Dump(os.Stdout)
+}
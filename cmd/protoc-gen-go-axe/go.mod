@@ -2,4 +2,7 @@ module google.golang.org/grpc/cmd/protoc-gen-go-axe
 
 go 1.9
 
-require google.golang.org/protobuf v1.23.0
+require (
+	google.golang.org/genproto v0.0.0-20210617175327-b9e0b3197ced
+	google.golang.org/protobuf v1.26.0
+)
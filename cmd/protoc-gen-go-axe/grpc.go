@@ -29,13 +29,13 @@ import (
 )
 
 const (
-	contextPackage = protogen.GoImportPath("context")
-	httpPackage    = protogen.GoImportPath("net/http")
-	ioutilPackage  = protogen.GoImportPath("io/ioutil")
-	jsonPackage    = protogen.GoImportPath("encoding/json")
-	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
-	codesPackage   = protogen.GoImportPath("google.golang.org/grpc/codes")
-	statusPackage  = protogen.GoImportPath("google.golang.org/grpc/status")
+	contextPackage   = protogen.GoImportPath("context")
+	httpPackage      = protogen.GoImportPath("net/http")
+	protojsonPackage = protogen.GoImportPath("google.golang.org/protobuf/encoding/protojson")
+	grpcPackage      = protogen.GoImportPath("google.golang.org/grpc")
+	codesPackage     = protogen.GoImportPath("google.golang.org/grpc/codes")
+	statusPackage    = protogen.GoImportPath("google.golang.org/grpc/status")
+	rpcPackage       = protogen.GoImportPath("github.com/fengbeihong/rpc-go/rpc")
 )
 
 // generateFile generates a _axe.pb.go file containing gRPC service definitions.
@@ -73,30 +73,156 @@ func generateFileContent(gen *protogen.Plugin, file *protogen.File, g *protogen.
 func genHttpService(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
 	serverType := service.GoName + "Server"
 
-	g.P("type MiddlewareFunc func(", httpPackage.Ident("Handler"), ") ", httpPackage.Ident("Handler"))
+	bindings := make([][]httpBinding, len(service.Methods))
+	for i, method := range service.Methods {
+		b, err := httpBindings(method)
+		if err != nil {
+			gen.Error(err)
+			return
+		}
+		bindings[i] = b
+	}
+
+	genHttpCodec(g, service)
+	genOpenAPI(g, service, bindings)
+
+	g.P("// Register", service.GoName, "HttpServer registers the http routes of ", service.GoName, " service to mux,")
+	g.P("// it panics if a route conflicts with one already registered.")
+	g.P("func Register", service.GoName, "HttpServer(mux *", rpcPackage.Ident("HttpMux"), ", srv ", serverType, ", middlewares ...", rpcPackage.Ident("MiddlewareFunc"), ") {")
+	g.P("handle := func(method, pattern string, h ", httpPackage.Ident("HandlerFunc"), ") {")
+	g.P("var hf ", httpPackage.Ident("Handler"), " = h")
+	g.P("for _, m := range middlewares {")
+	g.P("hf = m(hf)")
+	g.P("}")
+	g.P("mux.Handle(method, pattern, hf)")
+	g.P("}")
+	g.P("mux.AddOpenAPI(_", service.GoName, "_OpenAPI)")
 	g.P()
 
-	g.P("func Register", service.GoName, "HttpServer(s *", httpPackage.Ident("Server"), ",srv ", serverType, ", middlewares ...MiddlewareFunc) {")
-	g.P("mux := ", httpPackage.Ident("NewServeMux"), "()")
+	for i, method := range service.Methods {
+		if i > 0 {
+			g.P()
+		}
+		hname := genHttpServerMethod(gen, file, g, method)
+		for _, b := range bindings[i] {
+			g.P("handle(", strconv.Quote(b.method), ", ", strconv.Quote(b.pattern), ", ", hname, "(", strconv.Quote(b.body), "))")
+		}
+	}
+
+	g.P("}")
 	g.P()
 
+	// Server streaming auxiliary types.
 	for _, method := range service.Methods {
-		hname := genHttpServerMethod(gen, file, g, method)
+		if method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+			genHttpServerStream(g, method)
+		}
+	}
 
-		hf := fmt.Sprintf("_%s_%s_HandlerFunc", service.GoName, method.GoName)
-		g.P("var ", hf, " ", httpPackage.Ident("Handler"))
+	genHttpClient(g, service)
+}
 
-		g.P(hf, " = ", httpPackage.Ident("Handler"), "(", httpPackage.Ident("HandlerFunc"), "(", hname, "))")
-		g.P("for _, m := range middlewares {")
-		g.P(hf, " = m(", hf, ")")
-		g.P("}")
+// genHttpClient generates XxxHttpClient calling every method by its first google.api.http binding, or by the default route
+// /package.Service/Method, through the [[client]] config, with the same method signatures as the grpc client.
+func genHttpClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	clientName := service.GoName + "Client"
+	httpClientName := service.GoName + "HttpClient"
+
+	g.P("// ", httpClientName, " is the http client API for ", service.GoName, " service,")
+	g.P("// it has the same methods as ", clientName, " and calls the service through a [[client]] with proto=\"http\".")
+	g.P("type ", httpClientName, " interface {")
+	for _, method := range service.Methods {
+		if method.Desc.Options().(*descriptorpb.MethodOptions).GetDeprecated() {
+			g.P(deprecationComment)
+		}
+		g.P(method.Comments.Leading,
+			clientSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("type ", unexport(httpClientName), " struct {")
+	g.P("serviceName string")
+	g.P("}")
+	g.P()
+
+	g.P("// New", httpClientName, " creates a client of the [[client]] named serviceName in the config.")
+	g.P("func New", httpClientName, "(serviceName string) ", httpClientName, " {")
+	g.P("return &", unexport(httpClientName), "{serviceName}")
+	g.P("}")
+	g.P()
+
+	g.P("// New", clientName, "For creates the grpc or the http client by the proto of the [[client]] named serviceName in the config,")
+	g.P("// so that switching between them is a config change.")
+	g.P("func New", clientName, "For(ctx ", contextPackage.Ident("Context"), ", serviceName string) (", clientName, ", error) {")
+	g.P("if ", rpcPackage.Ident("IsHttpClient"), "(serviceName) {")
+	g.P("return New", httpClientName, "(serviceName), nil")
+	g.P("}")
+	g.P("conn, err := ", rpcPackage.Ident("DialService"), "(ctx, serviceName)")
+	g.P("if err != nil { return nil, err }")
+	g.P("return New", clientName, "(conn), nil")
+	g.P("}")
+	g.P()
 
-		g.P("mux.Handle(", strconv.Quote(fmt.Sprintf("/%s/%s", service.Desc.FullName(), method.Desc.Name())), ", ", hf, ")")
+	for _, method := range service.Methods {
+		uri := fmt.Sprintf("/%s/%s", service.Desc.FullName(), method.Desc.Name())
+		if method.Desc.Options().(*descriptorpb.MethodOptions).GetDeprecated() {
+			g.P(deprecationComment)
+		}
+		g.P("func (c *", unexport(httpClientName), ") ", clientSignature(g, method), "{")
+		switch {
+		case method.Desc.IsStreamingClient():
+			g.P("return nil, ", statusPackage.Ident("Error"), "(", codesPackage.Ident("Unimplemented"), `, "client streaming method `, method.GoName, ` is not supported over http")`)
+		case method.Desc.IsStreamingServer():
+			g.P("stream, err := ", rpcPackage.Ident("NewHttpClientStream"), "(ctx, c.serviceName, ", httpRoute(g, method, uri), ", in, _", service.GoName, "_HttpMarshalOptions, _", service.GoName, "_HttpUnmarshalOptions, opts...)")
+			g.P("if err != nil { return nil, err }")
+			g.P("return &", unexport(service.GoName), method.GoName, "Client{stream}, nil")
+		default:
+			g.P("out := new(", method.Output.GoIdent, ")")
+			g.P("err := ", rpcPackage.Ident("HttpInvoke"), "(ctx, c.serviceName, ", httpRoute(g, method, uri), ", in, out, _", service.GoName, "_HttpMarshalOptions, _", service.GoName, "_HttpUnmarshalOptions, opts...)")
+			g.P("if err != nil { return nil, err }")
+			g.P("return out, nil")
+		}
+		g.P("}")
 		g.P()
 	}
+}
+
+// httpRoute returns the rpc.HttpRoute literal of the first binding of method, the bindings are checked by genHttpService
+func httpRoute(g *protogen.GeneratedFile, method *protogen.Method, name string) string {
+	b := httpBinding{pattern: name, body: "*"}
+	if bindings, err := httpBindings(method); err == nil {
+		b = bindings[0]
+	}
+	route := g.QualifiedGoIdent(rpcPackage.Ident("HttpRoute")) + "{Name: " + strconv.Quote(name)
+	if b.method != "" {
+		route += ", Method: " + strconv.Quote(b.method)
+	}
+	route += ", Pattern: " + strconv.Quote(b.pattern)
+	if b.body != "" {
+		route += ", Body: " + strconv.Quote(b.body)
+	}
+	return route + "}"
+}
 
-	g.P("s.Handler = mux")
+// genHttpCodec generates the protojson options shared by the http handlers of service,
+// they are controlled by the json_* plugin parameters.
+func genHttpCodec(g *protogen.GeneratedFile, service *protogen.Service) {
+	g.P("var (")
+	g.P("_", service.GoName, "_HttpMarshalOptions = ", protojsonPackage.Ident("MarshalOptions"), "{")
+	if *jsonEmitUnpopulated {
+		g.P("EmitUnpopulated: true,")
+	}
+	if *jsonUseProtoNames {
+		g.P("UseProtoNames: true,")
+	}
+	g.P("}")
+	g.P("_", service.GoName, "_HttpUnmarshalOptions = ", protojsonPackage.Ident("UnmarshalOptions"), "{")
+	if *jsonDiscardUnknown {
+		g.P("DiscardUnknown: true,")
+	}
 	g.P("}")
+	g.P(")")
 	g.P()
 }
 
@@ -104,40 +230,61 @@ func genHttpServerMethod(gen *protogen.Plugin, file *protogen.File, g *protogen.
 	service := method.Parent
 	hname := fmt.Sprintf("_%s_%s_Http_Handler", service.GoName, method.GoName)
 
-	g.P(hname, " := func(w ", httpPackage.Ident("ResponseWriter"), ", req *", httpPackage.Ident("Request"), ") {")
-	g.P("data, err := ", ioutilPackage.Ident("ReadAll"), "(req.Body)")
-	g.P("defer req.Body.Close()")
-	g.P("if err != nil {")
-	g.P("w.Write([]byte(err.Error()))")
+	g.P(hname, " := func(body string) ", httpPackage.Ident("HandlerFunc"), " {")
+	g.P("return func(w ", httpPackage.Ident("ResponseWriter"), ", req *", httpPackage.Ident("Request"), ") {")
+	if method.Desc.IsStreamingClient() {
+		// the request of a http call is read as a whole, so only the response can be streamed
+		g.P(rpcPackage.Ident("WriteHttpError"), "(w, ", statusPackage.Ident("Error"), "(", codesPackage.Ident("Unimplemented"), `, "client streaming method `, method.GoName, ` is not supported over http"))`)
+		g.P("}")
+		g.P("}")
+		return hname
+	}
+	g.P("reqData := new(", method.Input.GoIdent, ")")
+	g.P("if err := ", rpcPackage.Ident("BindHttpRequest"), "(req, reqData, body, _", service.GoName, "_HttpUnmarshalOptions); err != nil {")
+	g.P(rpcPackage.Ident("WriteHttpError"), "(w, err)")
 	g.P("return")
 	g.P("}")
 
-	g.P("var reqData EchoRequest")
-	g.P("if len(data) != 0 {")
-	g.P("err = ", jsonPackage.Ident("Unmarshal"), "(data, &reqData)")
-	g.P("if err != nil {")
-	g.P("w.Write([]byte(err.Error()))")
-	g.P("return")
-	g.P("}")
-	g.P("}")
+	if method.Desc.IsStreamingServer() {
+		g.P("stream := ", rpcPackage.Ident("NewHttpServerStream"), "(w, req, _", service.GoName, "_HttpMarshalOptions)")
+		g.P("stream.Finish(srv.", method.GoName, "(reqData, &", unexport(service.GoName), method.GoName, "HttpServer{stream}))")
+		g.P("}")
+		g.P("}")
+		return hname
+	}
 
-	g.P("respData, err := srv.", method.GoName, "(context.Background(), &reqData)")
+	g.P("respData, err := srv.", method.GoName, "(req.Context(), reqData)")
 	g.P("if err != nil {")
-	g.P("w.Write([]byte(err.Error()))")
+	g.P(rpcPackage.Ident("WriteHttpError"), "(w, err)")
 	g.P("return")
 	g.P("}")
 
-	g.P("b, err := ", jsonPackage.Ident("Marshal"), "(respData)")
+	g.P("b, err := _", service.GoName, "_HttpMarshalOptions.Marshal(respData)")
 	g.P("if err != nil {")
-	g.P("w.Write([]byte(err.Error()))")
+	g.P(rpcPackage.Ident("WriteHttpError"), "(w, ", statusPackage.Ident("Errorf"), "(", codesPackage.Ident("Internal"), `, "encode response failed: %v", err))`)
 	g.P("return")
 	g.P("}")
+	g.P(`w.Header().Set("Content-Type", "application/json")`)
 	g.P("w.Write(b)")
 	g.P("}")
+	g.P("}")
 
 	return hname
 }
 
+// genHttpServerStream generates the http implementation of the Xxx_MethodServer stream interface
+func genHttpServerStream(g *protogen.GeneratedFile, method *protogen.Method) {
+	streamType := unexport(method.Parent.GoName) + method.GoName + "HttpServer"
+	g.P("type ", streamType, " struct {")
+	g.P("*", rpcPackage.Ident("HttpServerStream"))
+	g.P("}")
+	g.P()
+	g.P("func (x *", streamType, ") Send(m *", method.Output.GoIdent, ") error {")
+	g.P("return x.HttpServerStream.SendMsg(m)")
+	g.P("}")
+	g.P()
+}
+
 func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
 	clientName := service.GoName + "Client"
 
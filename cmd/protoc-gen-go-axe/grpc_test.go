@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenCases lists the proto files loaded from testdata for each case, in
+// dependency order. The last file is the one to generate.
+var goldenCases = []struct {
+	name   string
+	files  []string
+	param  string
+	golden string
+}{
+	{
+		name:   "echo",
+		files:  []string{"echo.textproto"},
+		golden: "echo_axe.pb.go.golden",
+	},
+	{
+		name:   "multiple services",
+		files:  []string{"user.textproto"},
+		golden: "user_axe.pb.go.golden",
+	},
+	{
+		name:   "imported message types",
+		files:  []string{"user.textproto", "order.textproto"},
+		golden: "order_axe.pb.go.golden",
+	},
+	{
+		name:   "legacy unimplemented servers",
+		files:  []string{"echo.textproto"},
+		param:  "require_unimplemented_servers=false",
+		golden: "echo_legacy_axe.pb.go.golden",
+	},
+	{
+		name:   "json options",
+		files:  []string{"user.textproto"},
+		param:  "json_emit_unpopulated=true,json_use_proto_names=true,json_discard_unknown=false",
+		golden: "user_json_axe.pb.go.golden",
+	},
+	{
+		name:   "google.api.http annotations",
+		files:  []string{"library.textproto"},
+		golden: "library_axe.pb.go.golden",
+	},
+	{
+		name:   "streaming methods",
+		files:  []string{"stream.textproto"},
+		golden: "stream_axe.pb.go.golden",
+	},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := runPlugin(t, tc.param, tc.files...)
+			if resp.Error != nil {
+				t.Fatalf("plugin error: %s", resp.GetError())
+			}
+			if len(resp.File) != 1 {
+				t.Fatalf("want 1 generated file, got %d", len(resp.File))
+			}
+			got := []byte(resp.File[0].GetContent())
+
+			golden := filepath.Join("testdata", tc.golden)
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file failed, run `go test -update` to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("generated code differs from %s, run `go test -update` to accept the change\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestInvalidHttpRule(t *testing.T) {
+	resp := runPlugin(t, "", "invalid_rule.textproto")
+	want := `example.invalid.v1.InvalidService.Get: invalid google.api.http option: path "/v1/{id}": field "id" not found in google.protobuf.Empty`
+	if resp.GetError() != want {
+		t.Errorf("plugin error = %q, want %q", resp.GetError(), want)
+	}
+}
+
+// runPlugin feeds the descriptors in testdata to the generator, the last one is generated.
+func runPlugin(t *testing.T, param string, files ...string) *pluginpb.CodeGeneratorResponse {
+	t.Helper()
+
+	req := &pluginpb.CodeGeneratorRequest{
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(emptypb.File_google_protobuf_empty_proto),
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(annotations.File_google_api_http_proto),
+			protodesc.ToFileDescriptorProto(annotations.File_google_api_annotations_proto),
+		},
+	}
+	if param != "" {
+		req.Parameter = proto.String(param)
+	}
+	for _, name := range files {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd := new(descriptorpb.FileDescriptorProto)
+		if err := prototext.Unmarshal(b, fd); err != nil {
+			t.Fatalf("parse %s failed: %v", name, err)
+		}
+		req.ProtoFile = append(req.ProtoFile, fd)
+	}
+	req.FileToGenerate = []string{req.ProtoFile[len(req.ProtoFile)-1].GetName()}
+
+	resetFlags()
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	return gen.Response()
+}
+
+func resetFlags() {
+	flags.VisitAll(func(f *flag.Flag) {
+		f.Value.Set(f.DefValue)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpBinding is one http route of a method
+type httpBinding struct {
+	method  string // http method, empty for any method
+	pattern string // path template, e.g. /v1/{name=shelves/*}/books
+	body    string // "*", a top level field name, or empty when the request has no body
+}
+
+// httpBindings returns the routes declared by the google.api.http option of method,
+// followed by the default route /package.Service/Method which accepts any http method with the whole message as body.
+// Client streaming methods only get the default route, which rejects the calls.
+func httpBindings(method *protogen.Method) ([]httpBinding, error) {
+	var bindings []httpBinding
+
+	rule, _ := proto.GetExtension(method.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if rule != nil && method.Desc.IsStreamingClient() {
+		return nil, fmt.Errorf("%s: google.api.http is not supported for client streaming methods", method.Desc.FullName())
+	}
+	if rule != nil {
+		rules := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+		for i, r := range rules {
+			if i > 0 && len(r.GetAdditionalBindings()) != 0 {
+				return nil, fmt.Errorf("%s: invalid google.api.http option: additional_bindings must not be nested", method.Desc.FullName())
+			}
+			b, err := newHttpBinding(method, r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid google.api.http option: %v", method.Desc.FullName(), err)
+			}
+			bindings = append(bindings, b)
+		}
+	}
+
+	bindings = append(bindings, httpBinding{
+		pattern: fmt.Sprintf("/%s/%s", method.Parent.Desc.FullName(), method.Desc.Name()),
+		body:    "*",
+	})
+	return bindings, nil
+}
+
+func newHttpBinding(method *protogen.Method, r *annotations.HttpRule) (httpBinding, error) {
+	b := httpBinding{body: r.GetBody()}
+	switch p := r.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		b.method, b.pattern = "GET", p.Get
+	case *annotations.HttpRule_Put:
+		b.method, b.pattern = "PUT", p.Put
+	case *annotations.HttpRule_Post:
+		b.method, b.pattern = "POST", p.Post
+	case *annotations.HttpRule_Delete:
+		b.method, b.pattern = "DELETE", p.Delete
+	case *annotations.HttpRule_Patch:
+		b.method, b.pattern = "PATCH", p.Patch
+	case *annotations.HttpRule_Custom:
+		b.method, b.pattern = strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath()
+	default:
+		return b, fmt.Errorf("missing http method and path")
+	}
+	if !strings.HasPrefix(b.pattern, "/") {
+		return b, fmt.Errorf("path %q must start with /", b.pattern)
+	}
+
+	input := method.Input.Desc
+	vars, err := pathVariables(b.pattern)
+	if err != nil {
+		return b, err
+	}
+	for _, v := range vars {
+		fd, err := lookupField(input, v)
+		if err != nil {
+			return b, fmt.Errorf("path %q: %v", b.pattern, err)
+		}
+		if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+			return b, fmt.Errorf("path %q: field %s must be a singular scalar", b.pattern, v)
+		}
+	}
+
+	if b.body != "" && b.body != "*" {
+		fd := input.Fields().ByName(protoreflect.Name(b.body))
+		if fd == nil {
+			return b, fmt.Errorf("body field %q not found in %s", b.body, input.FullName())
+		}
+		if fd.IsList() || fd.IsMap() || fd.Message() == nil {
+			return b, fmt.Errorf("body field %q must be a singular message", b.body)
+		}
+	}
+	return b, nil
+}
+
+// pathVariables returns the field paths of the variables in a path template
+func pathVariables(pattern string) ([]string, error) {
+	var vars []string
+	rest := pattern
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("path %q: unterminated variable", pattern)
+		}
+		v := rest[start+1 : start+end]
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			v = v[:i]
+		}
+		vars = append(vars, v)
+		rest = rest[start+end+1:]
+	}
+	return vars, nil
+}
+
+// lookupField resolves a dotted field path like "book.name" in md
+func lookupField(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("field %q not found in %s", path, md.FullName())
+		}
+		if i == len(names)-1 {
+			return fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("field %q: %s is not a message", path, name)
+		}
+		md = fd.Message()
+	}
+	return nil, fmt.Errorf("empty field path")
+}
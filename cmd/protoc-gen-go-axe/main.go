@@ -19,14 +19,17 @@
 // protoc-gen-go-axe is a plugin for the Google protocol buffer compiler to
 // generate Go code. Install it by building this program and making it
 // accessible within your PATH with the name:
+//
 //	protoc-gen-go-axe
 //
 // The 'go-grpc' suffix becomes part of the argument for the protocol compiler,
 // such that it can be invoked as:
+//
 //	protoc --go-axe_out=. path/to/file.proto
 //
 // This generates Go service definitions for the protocol buffer defined by
 // file.proto.  With that input, the output will be written to:
+//
 //	path/to/file_axe.pb.go
 package main
 
@@ -40,7 +43,15 @@ import (
 
 const version = "1.1.0"
 
-var requireUnimplemented *bool
+var (
+	flags                flag.FlagSet
+	requireUnimplemented = flags.Bool("require_unimplemented_servers", true, "set to false to match legacy behavior")
+
+	// protojson options of the generated http handlers
+	jsonEmitUnpopulated = flags.Bool("json_emit_unpopulated", false, "emit fields with zero values in http responses")
+	jsonUseProtoNames   = flags.Bool("json_use_proto_names", false, "use proto field names instead of lowerCamelCase json names in http responses")
+	jsonDiscardUnknown  = flags.Bool("json_discard_unknown", true, "ignore unknown fields in http requests instead of rejecting them")
+)
 
 func main() {
 	showVersion := flag.Bool("version", false, "print the version and exit")
@@ -50,19 +61,18 @@ func main() {
 		return
 	}
 
-	var flags flag.FlagSet
-	requireUnimplemented = flags.Bool("require_unimplemented_servers", true, "set to false to match legacy behavior")
-
 	protogen.Options{
 		ParamFunc: flags.Set,
-	}.Run(func(gen *protogen.Plugin) error {
-		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
-		for _, f := range gen.Files {
-			if !f.Generate {
-				continue
-			}
-			generateFile(gen, f)
+	}.Run(generate)
+}
+
+func generate(gen *protogen.Plugin) error {
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
 		}
-		return nil
-	})
+		generateFile(gen, f)
+	}
+	return nil
 }
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// The OpenAPI v3 document of the http routes of a service, see https://spec.openapis.org/oas/v3.0.3
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []openAPITag                            `json:"tags,omitempty"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	Tags        []string                    `json:"tags,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	OperationID string                      `json:"operationId"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Deprecated           bool                      `json:"deprecated,omitempty"`
+}
+
+const statusSchemaName = "google.rpc.Status"
+
+// genOpenAPI generates the OpenAPI document of the http routes of service as a string constant,
+// RegisterXxxHttpServer adds it to the HttpMux so that the server can serve it with the swagger ui.
+func genOpenAPI(g *protogen.GeneratedFile, service *protogen.Service, bindings [][]httpBinding) {
+	b := &openAPIBuilder{
+		schemas: map[string]*openAPISchema{
+			statusSchemaName: {
+				Type:        "object",
+				Description: "The error returned by all routes, the http status is derived from code.",
+				Properties: map[string]*openAPISchema{
+					"code":    {Type: "integer", Format: "int32", Description: "gRPC status code, see google.rpc.Code"},
+					"message": {Type: "string"},
+					"details": {Type: "array", Items: &openAPISchema{Type: "object"}},
+				},
+			},
+		},
+	}
+	doc := &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: string(service.Desc.FullName()), Version: "version not set"},
+		Tags: []openAPITag{{
+			Name:        string(service.Desc.FullName()),
+			Description: cleanComments(service.Comments.Leading),
+		}},
+		Paths: map[string]map[string]*openAPIOperation{},
+	}
+
+	for i, method := range service.Methods {
+		if method.Desc.IsStreamingClient() {
+			continue
+		}
+		annotated := len(bindings[i]) > 1
+		for j, binding := range bindings[i] {
+			// the default route is documented only for methods without google.api.http
+			if annotated && j == len(bindings[i])-1 {
+				continue
+			}
+			op := b.operation(method, binding)
+			if j > 0 {
+				op.OperationID = fmt.Sprintf("%s_%d", op.OperationID, j)
+			}
+			path, _ := openAPIPath(binding.pattern)
+			httpMethod := strings.ToLower(binding.method)
+			if httpMethod == "" {
+				httpMethod = "post"
+			}
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = map[string]*openAPIOperation{}
+			}
+			doc.Paths[path][httpMethod] = op
+		}
+	}
+	doc.Components.Schemas = b.schemas
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	g.P("// _", service.GoName, "_OpenAPI is the OpenAPI v3 document of the http routes of ", service.GoName, " service.")
+	if strings.Contains(string(data), "`") {
+		g.P("const _", service.GoName, "_OpenAPI = ", fmt.Sprintf("%q", data))
+	} else {
+		g.P("const _", service.GoName, "_OpenAPI = `", string(data), "`")
+	}
+	g.P()
+}
+
+type openAPIBuilder struct {
+	schemas map[string]*openAPISchema
+}
+
+func (b *openAPIBuilder) operation(method *protogen.Method, binding httpBinding) *openAPIOperation {
+	service := method.Parent
+	summary, description := splitComments(cleanComments(method.Comments.Leading))
+	op := &openAPIOperation{
+		Tags:        []string{string(service.Desc.FullName())},
+		Summary:     summary,
+		Description: description,
+		OperationID: fmt.Sprintf("%s_%s", service.GoName, method.GoName),
+		Deprecated:  method.Desc.Options() != nil && isDeprecated(method.Desc.Options()),
+		Responses: map[string]*openAPIResponse{
+			"default": {
+				Description: "An error response.",
+				Content:     jsonContent(b.ref(statusSchemaName)),
+			},
+		},
+	}
+
+	// path parameters
+	_, vars := openAPIPath(binding.pattern)
+	bound := map[string]bool{}
+	for _, v := range vars {
+		field := findProtogenField(method.Input, v)
+		if field == nil {
+			continue
+		}
+		bound[v] = true
+		op.Parameters = append(op.Parameters, &openAPIParameter{
+			Name:        v,
+			In:          "path",
+			Description: cleanComments(field.Comments.Leading),
+			Required:    true,
+			Schema:      b.fieldSchema(field),
+		})
+	}
+
+	// request body and query parameters
+	switch binding.body {
+	case "*":
+		op.RequestBody = &openAPIRequestBody{Required: true, Content: jsonContent(b.messageRef(method.Input))}
+	default:
+		for _, field := range method.Input.Fields {
+			name := string(field.Desc.Name())
+			if bound[name] || name == binding.body {
+				continue
+			}
+			if field.Desc.IsMap() || (field.Message != nil && !isWellKnownScalar(field.Message)) {
+				continue
+			}
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:        name,
+				In:          "query",
+				Description: cleanComments(field.Comments.Leading),
+				Schema:      b.fieldSchema(field),
+			})
+		}
+		if binding.body != "" {
+			field := findProtogenField(method.Input, binding.body)
+			op.RequestBody = &openAPIRequestBody{Required: true, Content: jsonContent(b.messageRef(field.Message))}
+		}
+	}
+
+	out := b.messageRef(method.Output)
+	if method.Desc.IsStreamingServer() {
+		op.Responses["200"] = &openAPIResponse{
+			Description: "A stream of messages, as server-sent events when the request accepts text/event-stream, otherwise newline delimited json.",
+			Content: map[string]*openAPIMediaType{
+				"application/x-ndjson": {Schema: &openAPISchema{
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"result": out,
+						"error":  b.ref(statusSchemaName),
+					},
+				}},
+				"text/event-stream": {Schema: out},
+			},
+		}
+	} else {
+		op.Responses["200"] = &openAPIResponse{Description: "A successful response.", Content: jsonContent(out)}
+	}
+	return op
+}
+
+func (b *openAPIBuilder) ref(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// messageRef returns the schema of a message, adding it and the messages it depends on to the components
+func (b *openAPIBuilder) messageRef(message *protogen.Message) *openAPISchema {
+	if s := wellKnownSchema(message); s != nil {
+		return s
+	}
+	name := string(message.Desc.FullName())
+	if _, ok := b.schemas[name]; ok {
+		return b.ref(name)
+	}
+
+	s := &openAPISchema{
+		Type:        "object",
+		Description: cleanComments(message.Comments.Leading),
+		Properties:  map[string]*openAPISchema{},
+	}
+	// registered before the fields to stop recursion
+	b.schemas[name] = s
+	for _, field := range message.Fields {
+		s.Properties[b.jsonName(field)] = b.fieldSchema(field)
+	}
+	return b.ref(name)
+}
+
+func (b *openAPIBuilder) jsonName(field *protogen.Field) string {
+	if *jsonUseProtoNames {
+		return string(field.Desc.Name())
+	}
+	return field.Desc.JSONName()
+}
+
+func (b *openAPIBuilder) fieldSchema(field *protogen.Field) *openAPISchema {
+	if field.Desc.IsMap() {
+		return &openAPISchema{
+			Type:                 "object",
+			Description:          cleanComments(field.Comments.Leading),
+			AdditionalProperties: b.singularSchema(field.Message.Fields[1]),
+		}
+	}
+	s := b.singularSchema(field)
+	if field.Desc.IsList() {
+		s = &openAPISchema{Type: "array", Items: s}
+	}
+	if desc := cleanComments(field.Comments.Leading); desc != "" {
+		if s.Ref != "" {
+			// siblings of $ref are ignored in OpenAPI 3.0
+			return s
+		}
+		s.Description = desc
+	}
+	return s
+}
+
+// singularSchema maps a field type to its proto3 json representation
+func (b *openAPIBuilder) singularSchema(field *protogen.Field) *openAPISchema {
+	switch field.Desc.Kind() {
+	case protoreflect.BoolKind:
+		return &openAPISchema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return &openAPISchema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &openAPISchema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case protoreflect.StringKind:
+		return &openAPISchema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &openAPISchema{Type: "string", Format: "byte"}
+	case protoreflect.EnumKind:
+		s := &openAPISchema{Type: "string"}
+		for _, v := range field.Enum.Values {
+			s.Enum = append(s.Enum, string(v.Desc.Name()))
+		}
+		return s
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return b.messageRef(field.Message)
+	}
+	return &openAPISchema{}
+}
+
+// wellKnownSchema returns the schema of the well-known types which have a special json representation
+func wellKnownSchema(message *protogen.Message) *openAPISchema {
+	switch message.Desc.FullName() {
+	case "google.protobuf.Timestamp":
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration":
+		return &openAPISchema{Type: "string", Description: "Duration in seconds with up to 9 fractional digits, suffixed with s, e.g. 1.5s"}
+	case "google.protobuf.FieldMask":
+		return &openAPISchema{Type: "string", Description: "Comma separated field paths"}
+	case "google.protobuf.Empty":
+		return &openAPISchema{Type: "object"}
+	case "google.protobuf.Struct":
+		return &openAPISchema{Type: "object", AdditionalProperties: &openAPISchema{}}
+	case "google.protobuf.Value":
+		return &openAPISchema{}
+	case "google.protobuf.ListValue":
+		return &openAPISchema{Type: "array", Items: &openAPISchema{}}
+	case "google.protobuf.Any":
+		return &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{"@type": {Type: "string"}}}
+	case "google.protobuf.StringValue":
+		return &openAPISchema{Type: "string"}
+	case "google.protobuf.BytesValue":
+		return &openAPISchema{Type: "string", Format: "byte"}
+	case "google.protobuf.BoolValue":
+		return &openAPISchema{Type: "boolean"}
+	case "google.protobuf.Int32Value":
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case "google.protobuf.UInt32Value":
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case "google.protobuf.Int64Value":
+		return &openAPISchema{Type: "string", Format: "int64"}
+	case "google.protobuf.UInt64Value":
+		return &openAPISchema{Type: "string", Format: "uint64"}
+	case "google.protobuf.FloatValue":
+		return &openAPISchema{Type: "number", Format: "float"}
+	case "google.protobuf.DoubleValue":
+		return &openAPISchema{Type: "number", Format: "double"}
+	}
+	return nil
+}
+
+// isWellKnownScalar reports whether a message is represented by a json scalar, so it can be a query parameter
+func isWellKnownScalar(message *protogen.Message) bool {
+	s := wellKnownSchema(message)
+	return s != nil && s.Type != "" && s.Type != "object" && s.Type != "array"
+}
+
+// openAPIPath converts a path template to an OpenAPI path, e.g. /v1/{name=shelves/*} to /v1/{name},
+// and returns the variable names.
+func openAPIPath(pattern string) (string, []string) {
+	var (
+		sb   strings.Builder
+		vars []string
+	)
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			sb.WriteString(pattern)
+			break
+		}
+		end := strings.IndexByte(pattern[start:], '}') + start
+		v := pattern[start+1 : end]
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			v = v[:i]
+		}
+		vars = append(vars, v)
+		sb.WriteString(pattern[:start])
+		sb.WriteString("{" + v + "}")
+		pattern = pattern[end+1:]
+	}
+	return sb.String(), vars
+}
+
+func findProtogenField(message *protogen.Message, path string) *protogen.Field {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		var found *protogen.Field
+		for _, f := range message.Fields {
+			if string(f.Desc.Name()) == name {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		if i == len(names)-1 {
+			return found
+		}
+		if found.Message == nil {
+			return nil
+		}
+		message = found.Message
+	}
+	return nil
+}
+
+func jsonContent(s *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: s}}
+}
+
+func cleanComments(c protogen.Comments) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(c)), "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitComments uses the first paragraph of comments as the summary and the rest as the description
+func splitComments(c string) (string, string) {
+	parts := strings.SplitN(c, "\n\n", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func isDeprecated(opts protoreflect.ProtoMessage) bool {
+	m := opts.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("deprecated")
+	return fd != nil && m.Get(fd).Bool()
+}
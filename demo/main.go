@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"log"
 	"net/http"
 	"path"
@@ -74,8 +72,8 @@ func main() {
 
 	// register rpc
 	pb.RegisterEchoServiceServer(s.GrpcServer(), &echoServer{})
-	// register http, pattern和handler会自动生成
-	pb.RegisterEchoServiceHttpServer(s.HttpServer(), &echoServer{}, []pb.MiddlewareFunc{middleware1, middleware2}...)
+	// register http, pattern和handler会自动生成，多个service注册到同一个HttpMux
+	pb.RegisterEchoServiceHttpServer(s.HttpMux(), &echoServer{}, []rpc.MiddlewareFunc{middleware1, middleware2}...)
 
 	// 调用client的例子
 	//go clientExample()
@@ -90,6 +88,7 @@ func clientExample() {
 	//clientExampleRpcConsul()
 	clientExampleRpcLocal()
 	clientExampleHttpLocal()
+	clientExampleByConfig()
 }
 
 // 通过consul调用启动的rpc服务
@@ -128,17 +127,28 @@ func clientExampleRpcLocal() {
 	log.Println("clientExampleRpcLocal succeed, response: ", r.Value)
 }
 
-// 通过local配置调用http服务
+// 通过local配置调用http服务，生成的http client和grpc client的方法一样
 func clientExampleHttpLocal() {
-	data := map[string]interface{}{
-		"value": "call http server with local",
-	}
-	bb, _ := json.Marshal(data)
-	body := bytes.NewReader(bb)
-	b, err := rpc.HttpPost(context.Background(), "rpcservername_http", "/echo", nil, body)
+	c := pb.NewEchoServiceHttpClient("rpcservername_http")
+	r, err := c.Echo(context.Background(), &pb.EchoRequest{Value: "call http server with local"})
 	if err != nil {
 		log.Println("clientExampleHttpLocal error: ", err)
 		return
 	}
-	log.Println("clientExampleHttpLocal succeed, response: ", string(b))
+	log.Println("clientExampleHttpLocal succeed, response: ", r.Value)
+}
+
+// 根据[[client]]的proto配置选择grpc或http调用，切换协议只需要修改配置
+func clientExampleByConfig() {
+	c, err := pb.NewEchoServiceClientFor(context.Background(), "rpcservername_http")
+	if err != nil {
+		log.Println("clientExampleByConfig error: ", err)
+		return
+	}
+	r, err := c.Echo(context.Background(), &pb.EchoRequest{Value: "call server by config"})
+	if err != nil {
+		log.Println("clientExampleByConfig error: ", err)
+		return
+	}
+	log.Println("clientExampleByConfig succeed, response: ", r.Value)
 }
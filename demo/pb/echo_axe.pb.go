@@ -4,11 +4,11 @@ package pb
 
 import (
 	context "context"
-	json "encoding/json"
+	rpc "github.com/fengbeihong/rpc-go/rpc"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
-	ioutil "io/ioutil"
+	protojson "google.golang.org/protobuf/encoding/protojson"
 	http "net/http"
 )
 
@@ -139,78 +139,251 @@ var EchoService_ServiceDesc = grpc.ServiceDesc{
 	Metadata: "pb/echo.proto",
 }
 
-type MiddlewareFunc func(http.Handler) http.Handler
+var (
+	_EchoService_HttpMarshalOptions   = protojson.MarshalOptions{}
+	_EchoService_HttpUnmarshalOptions = protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+	}
+)
 
-func RegisterEchoServiceHttpServer(s *http.Server, srv EchoServiceServer, middlewares ...MiddlewareFunc) {
-	mux := http.NewServeMux()
+// _EchoService_OpenAPI is the OpenAPI v3 document of the http routes of EchoService service.
+const _EchoService_OpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "EchoService",
+    "version": "version not set"
+  },
+  "tags": [
+    {
+      "name": "EchoService"
+    }
+  ],
+  "paths": {
+    "/EchoService/Echo": {
+      "post": {
+        "tags": [
+          "EchoService"
+        ],
+        "operationId": "EchoService_Echo",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/EchoRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "A successful response.",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/EchoResponse"
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "An error response.",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/google.rpc.Status"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/EchoService/Echo2": {
+      "post": {
+        "tags": [
+          "EchoService"
+        ],
+        "operationId": "EchoService_Echo2",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/EchoRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "A successful response.",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/EchoResponse"
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "An error response.",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/google.rpc.Status"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "EchoRequest": {
+        "type": "object",
+        "properties": {
+          "value": {
+            "type": "string"
+          }
+        }
+      },
+      "EchoResponse": {
+        "type": "object",
+        "properties": {
+          "value": {
+            "type": "string"
+          }
+        }
+      },
+      "google.rpc.Status": {
+        "type": "object",
+        "description": "The error returned by all routes, the http status is derived from code.",
+        "properties": {
+          "code": {
+            "type": "integer",
+            "format": "int32",
+            "description": "gRPC status code, see google.rpc.Code"
+          },
+          "details": {
+            "type": "array",
+            "items": {
+              "type": "object"
+            }
+          },
+          "message": {
+            "type": "string"
+          }
+        }
+      }
+    }
+  }
+}`
 
-	_EchoService_Echo_Http_Handler := func(w http.ResponseWriter, req *http.Request) {
-		data, err := ioutil.ReadAll(req.Body)
-		defer req.Body.Close()
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
+// RegisterEchoServiceHttpServer registers the http routes of EchoService service to mux,
+// it panics if a route conflicts with one already registered.
+func RegisterEchoServiceHttpServer(mux *rpc.HttpMux, srv EchoServiceServer, middlewares ...rpc.MiddlewareFunc) {
+	handle := func(method, pattern string, h http.HandlerFunc) {
+		var hf http.Handler = h
+		for _, m := range middlewares {
+			hf = m(hf)
 		}
-		var reqData EchoRequest
-		if len(data) != 0 {
-			err = json.Unmarshal(data, &reqData)
+		mux.Handle(method, pattern, hf)
+	}
+	mux.AddOpenAPI(_EchoService_OpenAPI)
+
+	_EchoService_Echo_Http_Handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			reqData := new(EchoRequest)
+			if err := rpc.BindHttpRequest(req, reqData, body, _EchoService_HttpUnmarshalOptions); err != nil {
+				rpc.WriteHttpError(w, err)
+				return
+			}
+			respData, err := srv.Echo(req.Context(), reqData)
 			if err != nil {
-				w.Write([]byte(err.Error()))
+				rpc.WriteHttpError(w, err)
 				return
 			}
+			b, err := _EchoService_HttpMarshalOptions.Marshal(respData)
+			if err != nil {
+				rpc.WriteHttpError(w, status.Errorf(codes.Internal, "encode response failed: %v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
 		}
-		respData, err := srv.Echo(context.Background(), &reqData)
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
-		}
-		b, err := json.Marshal(respData)
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
-		}
-		w.Write(b)
-	}
-	var _EchoService_Echo_HandlerFunc http.Handler
-	_EchoService_Echo_HandlerFunc = http.Handler(http.HandlerFunc(_EchoService_Echo_Http_Handler))
-	for _, m := range middlewares {
-		_EchoService_Echo_HandlerFunc = m(_EchoService_Echo_HandlerFunc)
 	}
-	mux.Handle("/EchoService/Echo", _EchoService_Echo_HandlerFunc)
+	handle("", "/EchoService/Echo", _EchoService_Echo_Http_Handler("*"))
 
-	_EchoService_Echo2_Http_Handler := func(w http.ResponseWriter, req *http.Request) {
-		data, err := ioutil.ReadAll(req.Body)
-		defer req.Body.Close()
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
-		}
-		var reqData EchoRequest
-		if len(data) != 0 {
-			err = json.Unmarshal(data, &reqData)
+	_EchoService_Echo2_Http_Handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			reqData := new(EchoRequest)
+			if err := rpc.BindHttpRequest(req, reqData, body, _EchoService_HttpUnmarshalOptions); err != nil {
+				rpc.WriteHttpError(w, err)
+				return
+			}
+			respData, err := srv.Echo2(req.Context(), reqData)
 			if err != nil {
-				w.Write([]byte(err.Error()))
+				rpc.WriteHttpError(w, err)
 				return
 			}
+			b, err := _EchoService_HttpMarshalOptions.Marshal(respData)
+			if err != nil {
+				rpc.WriteHttpError(w, status.Errorf(codes.Internal, "encode response failed: %v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
 		}
-		respData, err := srv.Echo2(context.Background(), &reqData)
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
-		}
-		b, err := json.Marshal(respData)
-		if err != nil {
-			w.Write([]byte(err.Error()))
-			return
-		}
-		w.Write(b)
 	}
-	var _EchoService_Echo2_HandlerFunc http.Handler
-	_EchoService_Echo2_HandlerFunc = http.Handler(http.HandlerFunc(_EchoService_Echo2_Http_Handler))
-	for _, m := range middlewares {
-		_EchoService_Echo2_HandlerFunc = m(_EchoService_Echo2_HandlerFunc)
+	handle("", "/EchoService/Echo2", _EchoService_Echo2_Http_Handler("*"))
+}
+
+// EchoServiceHttpClient is the http client API for EchoService service,
+// it has the same methods as EchoServiceClient and calls the service through a [[client]] with proto="http".
+type EchoServiceHttpClient interface {
+	Echo(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	Echo2(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+}
+
+type echoServiceHttpClient struct {
+	serviceName string
+}
+
+// NewEchoServiceHttpClient creates a client of the [[client]] named serviceName in the config.
+func NewEchoServiceHttpClient(serviceName string) EchoServiceHttpClient {
+	return &echoServiceHttpClient{serviceName}
+}
+
+// NewEchoServiceClientFor creates the grpc or the http client by the proto of the [[client]] named serviceName in the config,
+// so that switching between them is a config change.
+func NewEchoServiceClientFor(ctx context.Context, serviceName string) (EchoServiceClient, error) {
+	if rpc.IsHttpClient(serviceName) {
+		return NewEchoServiceHttpClient(serviceName), nil
+	}
+	conn, err := rpc.DialService(ctx, serviceName)
+	if err != nil {
+		return nil, err
 	}
-	mux.Handle("/EchoService/Echo2", _EchoService_Echo2_HandlerFunc)
+	return NewEchoServiceClient(conn), nil
+}
 
-	s.Handler = mux
+func (c *echoServiceHttpClient) Echo(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := rpc.HttpInvoke(ctx, c.serviceName, rpc.HttpRoute{Name: "/EchoService/Echo", Pattern: "/EchoService/Echo", Body: "*"}, in, out, _EchoService_HttpMarshalOptions, _EchoService_HttpUnmarshalOptions, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoServiceHttpClient) Echo2(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := rpc.HttpInvoke(ctx, c.serviceName, rpc.HttpRoute{Name: "/EchoService/Echo2", Pattern: "/EchoService/Echo2", Body: "*"}, in, out, _EchoService_HttpMarshalOptions, _EchoService_HttpUnmarshalOptions, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
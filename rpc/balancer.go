@@ -8,6 +8,8 @@ var (
 )
 
 // Balancer roundrobin instance
+//
+// Deprecated: the clients pick endpoints by the Picker of balance_type, see NewPicker
 type Balancer struct {
 	ch chan int
 
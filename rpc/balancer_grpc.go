@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+const grpcBalancerPrefix = "axe_"
+
+// the grpc load balancing policies use the same Pickers as the http clients
+func init() {
+	for _, balanceType := range []string{
+		BalanceTypeRoundRobin,
+		BalanceTypeRandom,
+		BalanceTypeWeightedRoundRobin,
+		BalanceTypeLeastRequest,
+		BalanceTypeP2C,
+		BalanceTypeConsistentHash,
+	} {
+		balancer.Register(base.NewBalancerBuilder(grpcBalancerPrefix+balanceType, &grpcPickerBuilder{balanceType: balanceType}, base.Config{HealthCheck: true}))
+	}
+}
+
+// grpcServiceConfig is the default service config selecting the load balancing policy of balance_type
+func grpcServiceConfig(balanceType string) string {
+	if balanceType == "" {
+		balanceType = BalanceTypeRoundRobin
+	}
+	return `{"loadBalancingPolicy":"` + grpcBalancerPrefix + balanceType + `"}`
+}
+
+// weightKey is the key of the weight in the attributes of resolver.Address
+type weightKey struct{}
+
+// clientKey is the key of the *clientConfig in the attributes of resolver.Address, the pickers use its outlier detection
+type clientKey struct{}
+
+func addressAttributes(cfg *clientConfig, weight int) *attributes.Attributes {
+	return attributes.New(weightKey{}, weight, clientKey{}, cfg)
+}
+
+func addressWeight(addr resolver.Address) int {
+	if addr.Attributes != nil {
+		if w, ok := addr.Attributes.Value(weightKey{}).(int); ok && w > 0 {
+			return w
+		}
+	}
+	return 1
+}
+
+// parseEndpoint parses the endpoint with an optional weight, e.g. 10.0.0.1:9900;w=3
+func parseEndpoint(endpoint string) (string, int) {
+	arr := strings.Split(strings.TrimSpace(endpoint), ";")
+	weight := 1
+	for _, item := range arr[1:] {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) == 2 && kv[0] == "w" {
+			if w, err := strconv.Atoi(kv[1]); err == nil && w > 0 {
+				weight = w
+			}
+		}
+	}
+	return strings.TrimSpace(arr[0]), weight
+}
+
+const staticScheme = "axe-static"
+
+// staticResolverBuilder resolves the grpc target axe-static:///<service name> to the endpoints of a local client
+type staticResolverBuilder struct {
+	cfg *clientConfig
+}
+
+func (b *staticResolverBuilder) Scheme() string {
+	return staticScheme
+}
+
+func (b *staticResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	var addrs []resolver.Address
+	for _, item := range strings.Split(b.cfg.Endpoints, ",") {
+		addr, weight := parseEndpoint(item)
+		if addr == "" {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{Addr: addr, Attributes: addressAttributes(b.cfg, weight)})
+	}
+	cc.UpdateState(resolver.State{Addresses: addrs})
+	return staticResolver{}, nil
+}
+
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (staticResolver) Close() {}
+
+// grpcPickerBuilder builds the Picker of balanceType over the ready subConns
+type grpcPickerBuilder struct {
+	balanceType string
+}
+
+func (b *grpcPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	var endpoints []Endpoint
+	var cfg *clientConfig
+	subConns := make(map[string]balancer.SubConn, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		endpoints = append(endpoints, Endpoint{Addr: sci.Address.Addr, Weight: addressWeight(sci.Address)})
+		subConns[sci.Address.Addr] = sc
+		if sci.Address.Attributes != nil {
+			cfg, _ = sci.Address.Attributes.Value(clientKey{}).(*clientConfig)
+		}
+	}
+	var picker Picker
+	var err error
+	if cfg != nil && cfg.outlier != nil {
+		picker, err = newOutlierPicker(b.balanceType, endpoints, cfg.outlier)
+	} else {
+		picker, err = NewPicker(b.balanceType, endpoints)
+	}
+	if err != nil {
+		return base.NewErrPicker(err)
+	}
+	return &grpcPicker{picker: picker, subConns: subConns}
+}
+
+type grpcPicker struct {
+	picker   Picker
+	subConns map[string]balancer.SubConn
+}
+
+func (p *grpcPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	ep, done, err := p.picker.Pick(info.Ctx)
+	if err != nil {
+		return balancer.PickResult{}, err
+	}
+	return balancer.PickResult{
+		SubConn: p.subConns[ep.Addr],
+		Done: func(di balancer.DoneInfo) {
+			done(di.Err)
+		},
+	}, nil
+}
@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	cases := map[string]struct {
+		addr   string
+		weight int
+	}{
+		"10.0.0.1:9900":       {"10.0.0.1:9900", 1},
+		" 10.0.0.1:9900;w=3 ": {"10.0.0.1:9900", 3},
+		"10.0.0.1:9900;w=x":   {"10.0.0.1:9900", 1},
+		"http://10.0.0.1;w=0": {"http://10.0.0.1", 1},
+	}
+	for in, want := range cases {
+		if addr, weight := parseEndpoint(in); addr != want.addr || weight != want.weight {
+			t.Errorf("parseEndpoint(%q) = %s, %d, want %s, %d", in, addr, weight, want.addr, want.weight)
+		}
+	}
+}
+
+// startCountingServers starts grpc servers counting the health checks they serve
+func startCountingServers(t *testing.T, n int) ([]string, []*int64) {
+	var addrs []string
+	var counts []*int64
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := new(int64)
+		gs := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			atomic.AddInt64(count, 1)
+			return handler(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(gs, health.NewServer())
+		go gs.Serve(lis)
+		t.Cleanup(gs.Stop)
+		addrs = append(addrs, lis.Addr().String())
+		counts = append(counts, count)
+	}
+	return addrs, counts
+}
+
+func TestDialWithLocalBalance(t *testing.T) {
+	cases := []struct {
+		balanceType string
+		endpoints   string
+		min, max    []int64
+	}{
+		{BalanceTypeRoundRobin, "{0},{1}", []int64{50, 50}, []int64{50, 50}},
+		{BalanceTypeRandom, "{0},{1}", []int64{20, 20}, []int64{80, 80}},
+		{BalanceTypeWeightedRoundRobin, "{0};w=3,{1}", []int64{75, 25}, []int64{75, 25}},
+		{BalanceTypeLeastRequest, "{0},{1}", []int64{20, 20}, []int64{80, 80}},
+		{BalanceTypeP2C, "{0},{1}", []int64{20, 20}, []int64{80, 80}},
+	}
+	for _, c := range cases {
+		t.Run(c.balanceType, func(t *testing.T) {
+			addrs, counts := startCountingServers(t, 2)
+			cfg := &clientConfig{
+				ServiceName: "balance_test",
+				ProtoType:   protoTypeRpc,
+				CallType:    callTypeLocal,
+				BalanceType: c.balanceType,
+				Endpoints:   strings.NewReplacer("{0}", addrs[0], "{1}", addrs[1]).Replace(c.endpoints),
+				Timeout:     1000,
+			}
+			conn, err := dialWithLocal(context.Background(), cfg, grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			client := healthpb.NewHealthClient(conn)
+			check := func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// wait until both endpoints are ready
+			for atomic.LoadInt64(counts[0]) == 0 || atomic.LoadInt64(counts[1]) == 0 {
+				check()
+			}
+			atomic.StoreInt64(counts[0], 0)
+			atomic.StoreInt64(counts[1], 0)
+
+			for i := 0; i < 100; i++ {
+				check()
+			}
+			for i, count := range counts {
+				if n := atomic.LoadInt64(count); n < c.min[i] || n > c.max[i] {
+					t.Errorf("endpoint %d served %d requests, want [%d, %d]", i, n, c.min[i], c.max[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned by the clients without sending the request when the circuit breaker is open
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+var breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "axe_client_circuit_breaker_state",
+	Help: "State of the circuit breakers of the clients, 0 closed, 1 open, 2 half open.",
+}, []string{"service", "method"})
+
+func init() {
+	prometheus.MustRegister(breakerState)
+}
+
+// breakerConfig is [client.breaker] of a [[client]], e.g.
+//
+//	[client.breaker]
+//	enabled = true
+//	failure_ratio = 0.5
+type breakerConfig struct {
+	Enabled          bool
+	PerMethod        bool    `toml:"per_method"`                     // 每个方法一个熔断器，默认整个服务一个
+	FailureRatio     float64 `toml:"failure_ratio" default:"0.5"`    // interval内失败率达到多少后熔断
+	MinRequests      int     `toml:"min_requests" default:"20"`      // interval内请求数达到多少后才判断失败率
+	Interval         int     `toml:"interval" default:"10000"`       // 统计失败率的时间窗口(ms)
+	OpenDuration     int     `toml:"open_duration" default:"5000"`   // 熔断持续的时间(ms)，之后进入半开状态
+	HalfOpenRequests int     `toml:"half_open_requests" default:"3"` // 半开状态放行的探测请求数，都成功后恢复
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker is closed normally, it opens when the failure ratio is reached and rejects the requests for open_duration,
+// then it is half open and lets half_open_requests requests through, it closes when all of them succeed and opens again when one fails.
+type circuitBreaker struct {
+	serviceName string
+	method      string
+	cfg         breakerConfig
+	now         func() time.Time
+
+	mu          sync.Mutex
+	state       circuitState
+	generation  uint64 // increased when the state changes, the results of the requests of the previous states are ignored
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int // the requests let through in the half open state
+	successes   int
+}
+
+// allow returns ErrCircuitOpen when the request is rejected, otherwise done must be called with the result of the request
+func (b *circuitBreaker) allow() (func(err error), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < time.Duration(b.cfg.OpenDuration)*time.Millisecond {
+			return nil, ErrCircuitOpen
+		}
+		b.setState(circuitHalfOpen, now)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+	case circuitClosed:
+		if interval := time.Duration(b.cfg.Interval) * time.Millisecond; interval > 0 && now.Sub(b.windowStart) >= interval {
+			b.requests, b.failures, b.windowStart = 0, 0, now
+		}
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.done(generation, err) })
+	}, nil
+}
+
+func (b *circuitBreaker) done(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	now := b.now()
+	failed := isEndpointFailure(err)
+	switch b.state {
+	case circuitHalfOpen:
+		if failed {
+			b.setState(circuitOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(circuitClosed, now)
+		}
+	case circuitClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.FailureRatio*float64(b.requests) && b.failures > 0 {
+			b.setState(circuitOpen, now)
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(state circuitState, now time.Time) {
+	switch {
+	case state == circuitOpen && b.state == circuitHalfOpen:
+		gLogger.Error("circuit breaker of %s %s is open again, a probe request failed", b.serviceName, b.method)
+	case state == circuitOpen:
+		gLogger.Error("circuit breaker of %s %s is open, %d of %d requests failed", b.serviceName, b.method, b.failures, b.requests)
+	}
+	b.state = state
+	b.generation++
+	b.requests, b.failures, b.windowStart = 0, 0, now
+	b.probes, b.successes = 0, 0
+	b.openedAt = now
+	breakerState.WithLabelValues(b.serviceName, b.method).Set(float64(state))
+}
+
+// breakerGroup holds the circuit breakers of a client, one for the service or one for each method with per_method
+type breakerGroup struct {
+	serviceName string
+	cfg         breakerConfig
+	now         func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newBreakerGroup(serviceName string, cfg breakerConfig) *breakerGroup {
+	return &breakerGroup{serviceName: serviceName, cfg: cfg, now: time.Now, breakers: make(map[string]*circuitBreaker)}
+}
+
+func (g *breakerGroup) allow(method string) (func(err error), error) {
+	if !g.cfg.PerMethod {
+		method = ""
+	}
+	g.mu.Lock()
+	b := g.breakers[method]
+	if b == nil {
+		b = &circuitBreaker{serviceName: g.serviceName, method: method, cfg: g.cfg, now: g.now, windowStart: g.now()}
+		g.breakers[method] = b
+	}
+	g.mu.Unlock()
+	return b.allow()
+}
+
+// breakerUnaryClientInterceptor rejects the calls with ErrCircuitOpen when the circuit breaker of the client is open
+func breakerUnaryClientInterceptor(g *breakerGroup) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := g.allow(method)
+		if err != nil {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
@@ -0,0 +1,164 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	g := newBreakerGroup("breaker_test", breakerConfig{
+		Enabled:          true,
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Interval:         10000,
+		OpenDuration:     1000,
+		HalfOpenRequests: 2,
+	})
+	g.now = func() time.Time { return now }
+
+	call := func(err error) error {
+		done, rerr := g.allow("/pkg.Svc/Get")
+		if rerr != nil {
+			return rerr
+		}
+		done(err)
+		return nil
+	}
+	failure := errors.New("connection refused")
+	for _, err := range []error{nil, status.Error(codes.NotFound, "not found"), failure} {
+		call(err)
+	}
+	if err := call(failure); err != nil {
+		t.Fatalf("call before the circuit opens = %v", err)
+	}
+	if err := call(nil); err != ErrCircuitOpen {
+		t.Fatalf("call after 2 of 4 requests failed = %v, want ErrCircuitOpen", err)
+	}
+
+	// half open after open_duration, a failed probe opens it again
+	now = now.Add(time.Second)
+	if err := call(failure); err != nil {
+		t.Fatalf("probe = %v", err)
+	}
+	if err := call(nil); err != ErrCircuitOpen {
+		t.Fatalf("call after the probe failed = %v, want ErrCircuitOpen", err)
+	}
+
+	// only half_open_requests probes are let through, the circuit closes when they succeed
+	now = now.Add(time.Second)
+	done1, err1 := g.allow("")
+	done2, err2 := g.allow("")
+	if _, err := g.allow(""); err1 != nil || err2 != nil || err != ErrCircuitOpen {
+		t.Fatalf("probes = %v, %v, %v", err1, err2, err)
+	}
+	done1(nil)
+	done2(nil)
+	done2(failure) // done is called once
+	for i := 0; i < 10; i++ {
+		if err := call(nil); err != nil {
+			t.Fatalf("call after the circuit closes = %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerPerMethod(t *testing.T) {
+	g := newBreakerGroup("breaker_test", breakerConfig{Enabled: true, PerMethod: true, FailureRatio: 0.5, MinRequests: 1, Interval: 10000, OpenDuration: 1000, HalfOpenRequests: 1})
+	interceptor := breakerUnaryClientInterceptor(g)
+	var calls int32
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&calls, 1)
+		if method == "/pkg.Svc/Bad" {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	interceptor(context.Background(), "/pkg.Svc/Bad", nil, nil, nil, invoker)
+	if err := interceptor(context.Background(), "/pkg.Svc/Bad", nil, nil, nil, invoker); err != ErrCircuitOpen {
+		t.Errorf("call of the failed method = %v, want ErrCircuitOpen", err)
+	}
+	if err := interceptor(context.Background(), "/pkg.Svc/Good", nil, nil, nil, invoker); err != nil {
+		t.Errorf("call of another method = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("invoked %d times, want 2", calls)
+	}
+}
+
+func TestHttpClientBreaker(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	cfg := &clientConfig{
+		ServiceName:     "http_breaker_test",
+		ProtoType:       protoTypeHttp,
+		Timeout:         1000,
+		RetryTimes:      3,
+		RetryTimeout:    500,
+		EndpointStrList: []string{ts.URL},
+		breaker:         newBreakerGroup("http_breaker_test", breakerConfig{Enabled: true, FailureRatio: 0.5, MinRequests: 5, Interval: 10000, OpenDuration: 10000, HalfOpenRequests: 1}),
+	}
+	clientConfigMap[cfg.ServiceName] = cfg
+	defer delete(clientConfigMap, cfg.ServiceName)
+
+	var err error
+	for i := 0; i < 6; i++ {
+		_, err = HttpGet(context.Background(), cfg.ServiceName, "/", nil)
+	}
+	if err != ErrCircuitOpen || atomic.LoadInt32(&requests) != 5 {
+		t.Errorf("error = %v after %d requests, want ErrCircuitOpen after 5", err, requests)
+	}
+}
+
+func TestHttpClientBreakerPerRoute(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	g := newBreakerGroup("http_route_breaker_test", breakerConfig{Enabled: true, PerMethod: true, FailureRatio: 0.5, MinRequests: 2, Interval: 10000, OpenDuration: 10000, HalfOpenRequests: 1})
+	cfg := &clientConfig{
+		ServiceName:     "http_route_breaker_test",
+		ProtoType:       protoTypeHttp,
+		Timeout:         1000,
+		EndpointStrList: []string{ts.URL},
+		breaker:         g,
+	}
+	clientConfigMap[cfg.ServiceName] = cfg
+	defer delete(clientConfigMap, cfg.ServiceName)
+
+	// the paths with different ids share the breaker of the route
+	route := HttpRoute{Name: "/pkg.Svc/Get", Method: "GET", Pattern: "/v1/fields/{name}"}
+	var err error
+	for _, id := range []string{"1", "2", "3"} {
+		err = HttpInvoke(context.Background(), cfg.ServiceName, route, &descriptorpb.FieldDescriptorProto{Name: proto.String(id)}, new(descriptorpb.FieldDescriptorProto), protojson.MarshalOptions{}, protojson.UnmarshalOptions{})
+	}
+	if err != ErrCircuitOpen || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("error = %v after %d requests, want ErrCircuitOpen after 2", err, requests)
+	}
+	for _, id := range []string{"1", "2"} {
+		HttpGet(context.Background(), cfg.ServiceName, "/v1/users/"+id, nil)
+	}
+	if len(g.breakers) != 2 {
+		t.Errorf("%d breakers, want one of the route and one of the service", len(g.breakers))
+	}
+}
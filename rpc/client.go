@@ -1,8 +1,13 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -12,37 +17,213 @@ var (
 
 var clientConfigMap map[string]*clientConfig
 
+var (
+	// guards clientConfigMap, it is replaced as a whole when the config is reloaded
+	clientConfigMu sync.RWMutex
+	// guards EndpointStrList and Picker of the clients, they are updated by the registry watchers
+	clientEndpointsMu sync.RWMutex
+)
+
 func init() {
 	clientConfigMap = make(map[string]*clientConfig)
 }
 
 func initRpcClient(s *Server) {
+	clients := make(map[string]*clientConfig)
 	for _, item := range s.cfg.RpcClients {
-		if item.CallType == callTypeLocal {
-			if err := item.loadEndpoints(); err != nil {
-				s.Log.Error(err.Error())
+		c, err := newClientConfig(item, nil)
+		if err != nil {
+			s.Log.Error(err.Error())
+			continue
+		}
+		if err := c.start(s.registry); err != nil {
+			s.Log.Error(err.Error())
+		}
+		clients[c.ServiceName] = c
+	}
+	setClientConfigs(clients)
+}
+
+// newClientConfig builds the client of item, old is returned when item is not changed from it,
+// so that the state of the client, e.g. the outlier detection and the grpc connection, is kept.
+func newClientConfig(item clientConfig, old *clientConfig) (*clientConfig, error) {
+	if old != nil && sameClientConfig(&item, old) {
+		return old, nil
+	}
+	if item.Outlier.Enabled {
+		item.outlier = newOutlierDetector(item.ServiceName, item.Outlier)
+	}
+	if item.Breaker.Enabled {
+		item.breaker = newBreakerGroup(item.ServiceName, item.Breaker)
+	}
+	retry, err := newRetryPolicy(&item)
+	if err != nil {
+		return nil, err
+	}
+	item.retry = retry
+	if item.CallType == callTypeLocal {
+		if err := item.loadEndpoints(); err != nil {
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+// sameClientConfig reports whether the [[client]] configs are the same, only the fields of the toml file are compared,
+// the fields built from them are not read, e.g. EndpointStrList is written by the registry watcher of a running client
+func sameClientConfig(a, b *clientConfig) bool {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		if tomlKey(va.Type().Field(i)) == "" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// start watches the endpoints of the http clients discovered by the registry,
+// the grpc clients discover by the resolver of the registry, see dialWithRegistry
+func (c *clientConfig) start(r Registry) error {
+	if c.stopWatch != nil || c.ProtoType != protoTypeHttp || (c.CallType != callTypeConsul && c.CallType != callTypeRegistry) {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopWatch = cancel
+	return c.watchEndpoints(ctx, r)
+}
+
+func (c *clientConfig) stop() {
+	if c.stopWatch != nil {
+		c.stopWatch()
+	}
+}
+
+// setClientConfigs replaces all the clients, the clients removed or changed are stopped
+// and the grpc connections dialed before follow the new configs
+func setClientConfigs(clients map[string]*clientConfig) {
+	clientConfigMu.Lock()
+	old := clientConfigMap
+	clientConfigMap = clients
+	clientConfigMu.Unlock()
+
+	for name, c := range old {
+		if clients[name] != c {
+			c.stop()
+		}
+	}
+	grpcConns.refresh()
+}
+
+// stopClientWatchers stops the registry watchers of the http clients
+func stopClientWatchers() {
+	clientConfigMu.RLock()
+	defer clientConfigMu.RUnlock()
+	for _, c := range clientConfigMap {
+		c.stop()
+	}
+}
+
+// registryName is the name the instances of the client are discovered by, registry_name or the name registered by the server
+func (c *clientConfig) registryName() string {
+	if c.RegistryName != "" {
+		return c.RegistryName
+	}
+	if c.ProtoType == protoTypeHttp {
+		return HttpServiceName(c.ServiceName)
+	}
+	return c.ServiceName
+}
+
+// watchEndpoints keeps the endpoints of a http client up to date with the instances of registryName in the registry,
+// it waits the first result for at most the timeout of the client. When the registry fails the last endpoints are kept.
+func (c *clientConfig) watchEndpoints(ctx context.Context, r Registry) error {
+	if r == nil {
+		return fmt.Errorf("no registry for http client %s", c.ServiceName)
+	}
+	name := c.registryName()
+	w, err := r.Watch(ctx, name)
+	if err != nil {
+		return fmt.Errorf("watch %s in registry failed, error: %s", name, err.Error())
+	}
+
+	ready := make(chan struct{})
+	go func() {
+		defer w.Stop()
+		first := true
+		for {
+			list, err := w.Next()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				gLogger.Error("watch %s in registry failed, error: %s", name, err.Error())
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return
+				}
 				continue
 			}
+			endpoints := make([]Endpoint, 0, len(list))
+			for _, ins := range list {
+				weight, _ := strconv.Atoi(ins.Meta["weight"])
+				endpoints = append(endpoints, Endpoint{Addr: ins.Address, Weight: weight})
+			}
+			c.setEndpoints(endpoints)
+			if first {
+				first = false
+				close(ready)
+			}
 		}
-		tmp := item
-		clientConfigMap[item.ServiceName] = &tmp
+	}()
+
+	select {
+	case <-ready:
+	case <-time.After(time.Duration(c.Timeout) * time.Millisecond):
+		gLogger.Error("no endpoints of %s from registry in %d ms", name, c.Timeout)
 	}
+	return nil
+}
+
+func (c *clientConfig) setEndpoints(endpoints []Endpoint) {
+	var picker Picker
+	if len(endpoints) > 0 {
+		var err error
+		if picker, err = c.newPicker(endpoints); err != nil {
+			gLogger.Error("create picker of %s failed, error: %s", c.ServiceName, err.Error())
+			return
+		}
+	}
+	list := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		list = append(list, ep.Addr)
+	}
+
+	clientEndpointsMu.Lock()
+	defer clientEndpointsMu.Unlock()
+	c.EndpointStrList = list
+	c.Picker = picker
 }
 
-// load balancer
-func (c *clientConfig) endpointByBalancer() string {
-	// 暂时只有一种roundrobin负载均衡策略
+// pickEndpoint picks an endpoint by balance_type, done must be called with the result of the request
+func (c *clientConfig) pickEndpoint(ctx context.Context) (string, func(error)) {
+	clientEndpointsMu.RLock()
+	defer clientEndpointsMu.RUnlock()
+
 	if len(c.EndpointStrList) == 0 {
-		return ""
+		return "", nopDone
 	}
-	if c.Balancer == nil {
-		return c.EndpointStrList[0]
+	if c.Picker == nil {
+		return c.EndpointStrList[0], nopDone
 	}
-	v, err := c.Balancer.Pick()
+	ep, done, err := c.Picker.Pick(ctx)
 	if err != nil {
-		return c.EndpointStrList[0]
+		return c.EndpointStrList[0], nopDone
 	}
-	return v.(string)
+	return ep.Addr, done
 }
 
 func (c *clientConfig) loadEndpoints() error {
@@ -51,17 +232,38 @@ func (c *clientConfig) loadEndpoints() error {
 		return fmt.Errorf("check endpoints failed, empty ip address, service_name: %s, endpoints: %s", c.ServiceName, c.Endpoints)
 	}
 
-	var l []interface{}
+	var endpoints []Endpoint
+	var list []string
 	for _, item := range arr {
-		l = append(l, item)
+		addr, weight := parseEndpoint(item)
+		endpoints = append(endpoints, Endpoint{Addr: addr, Weight: weight})
+		list = append(list, addr)
 	}
 
 	// load balancer
-	c.Balancer = NewBalancer(l)
-	c.EndpointStrList = arr
+	picker, err := c.newPicker(endpoints)
+	if err != nil {
+		return fmt.Errorf("check balance type failed, service_name: %s, error: %s", c.ServiceName, err.Error())
+	}
+	c.Picker = picker
+	c.EndpointStrList = list
 	return nil
 }
 
+// retryPolicy returns the retry policy of the client, the clients not created by initRpcClient get a policy without the shared budget
+func (c *clientConfig) retryPolicy() *retryPolicy {
+	if c.retry != nil {
+		return c.retry
+	}
+	p, err := newRetryPolicy(c)
+	if err != nil {
+		p, _ = newRetryPolicy(&clientConfig{RetryTimes: c.RetryTimes, RetryTimeout: c.RetryTimeout})
+	}
+	return p
+}
+
 func getClientConfig(name string) *clientConfig {
+	clientConfigMu.RLock()
+	defer clientConfigMu.RUnlock()
 	return clientConfigMap[name]
 }
@@ -1,13 +1,21 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
-	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 type httpclientOption struct {
@@ -16,8 +24,12 @@ type httpclientOption struct {
 	ctx         context.Context
 	serviceName string
 	uri         string
+	name        string // the method name of the [[client.method]] config, the path of uri by default
+	breakerName string // the method of the per_method breaker, see httpBreakerName
 	headers     map[string]string
 	body        io.Reader
+	payload     []byte      // body read once so that it can be sent again by retries
+	respHeader  http.Header // headers of the last response
 }
 
 func HttpGet(ctx context.Context, serviceName string, uri string, headers map[string]string, body ...io.Reader) ([]byte, error) {
@@ -65,35 +77,88 @@ func httpOperation(opt *httpclientOption) (b []byte, err error) {
 	}
 
 	opt.cfg = cfg
-
-	c := make(chan struct{})
-	go func() {
-		b, err = httpDoWithRetry(opt)
-		c <- struct{}{}
-	}()
-
-	select {
-	case <-c:
-	case <-time.After(time.Duration(cfg.Timeout) * time.Millisecond):
-		return nil, fmt.Errorf("http operation timeout for total %d ms, retry %d times", cfg.Timeout, cfg.RetryTimes)
+	if opt.name == "" {
+		opt.name = httpMethod(opt.uri)
+		opt.breakerName = cfg.httpBreakerName(opt.name)
+	} else {
+		opt.breakerName = opt.name
+	}
+	if opt.ctx == nil {
+		opt.ctx = context.Background()
+	}
+	if opt.body != nil {
+		if opt.payload, err = ioutil.ReadAll(opt.body); err != nil {
+			return nil, fmt.Errorf("http request read body failed, service name: %s, error: %s", opt.serviceName, err.Error())
+		}
 	}
 
+	// the total timeout stops the retries
+	timeout := cfg.callTimeout(opt.name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		opt.ctx, cancel = context.WithTimeout(opt.ctx, timeout)
+		defer cancel()
+	}
+	b, err = httpDoWithBreaker(opt)
+	if err != nil && opt.ctx.Err() == context.DeadlineExceeded {
+		return nil, status.Errorf(codes.DeadlineExceeded, "http operation timeout for total %d ms, retry %d times", timeout.Milliseconds(), cfg.RetryTimes)
+	}
 	return
 }
 
-func httpDoWithRetry(opt *httpclientOption) (b []byte, err error) {
-	for i := 0; i < int(opt.cfg.RetryTimes); i++ {
-		b, err = httpDo(opt)
-		if err == nil {
-			break
+// httpMethod is the method of a http request for the [[client.method]] config, the path of uri
+func httpMethod(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// httpBreakerName is the method of the per_method breaker of a request sent by HttpGet or HttpPost, the path when it is
+// configured by [[client.method]], otherwise empty, so that the paths with ids neither create a breaker each nor trip apart.
+// HttpInvoke uses the name of the route.
+func (c *clientConfig) httpBreakerName(path string) string {
+	for _, m := range c.Methods {
+		if m.Name == path {
+			return path
 		}
 	}
-	return
+	return ""
 }
 
-// TODO tracer breaker ...
-func httpDo(opt *httpclientOption) ([]byte, error) {
-	domain := opt.cfg.endpointByBalancer()
+// httpDoWithBreaker rejects the request with ErrCircuitOpen when the circuit breaker of the client is open,
+// it is outside the retries like the grpc interceptors
+func httpDoWithBreaker(opt *httpclientOption) ([]byte, error) {
+	if opt.cfg.breaker == nil {
+		return httpDoWithRetry(opt)
+	}
+	done, err := opt.cfg.breaker.allow(opt.breakerName)
+	if err != nil {
+		return nil, err
+	}
+	b, err := httpDoWithRetry(opt)
+	done(err)
+	return b, err
+}
+
+// httpDoWithRetry retries the request by the retry policy of the client, the body is sent again from payload
+func httpDoWithRetry(opt *httpclientOption) (b []byte, err error) {
+	policy := opt.cfg.retryPolicy()
+	err = policy.do(opt.ctx, opt.name, policy.isIdempotent(opt.name, opt.method), func(ctx context.Context) error {
+		var err error
+		b, err = httpDo(ctx, opt)
+		return err
+	})
+	return b, err
+}
+
+// TODO tracer ...
+func httpDo(ctx context.Context, opt *httpclientOption) (b []byte, err error) {
+	domain, done := opt.cfg.pickEndpoint(ctx)
+	defer func() { done(err) }()
+	if domain == "" {
+		return nil, status.Errorf(codes.Unavailable, "no available endpoint, service name: %s", opt.serviceName)
+	}
 
 	if !strings.HasPrefix(domain, "http://") && !strings.HasPrefix(domain, "https://") {
 		domain = "http://" + domain
@@ -102,10 +167,13 @@ func httpDo(opt *httpclientOption) ([]byte, error) {
 	url := domain + opt.uri
 
 	c := &http.Client{
-		Timeout:   time.Duration(opt.cfg.RetryTimeout) * time.Millisecond,
 		Transport: http.DefaultTransport,
 	}
-	req, err := http.NewRequest(opt.method, url, opt.body)
+	var body io.Reader
+	if opt.payload != nil {
+		body = bytes.NewReader(opt.payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, opt.method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute http request, service_name: %s, url: %s, error: %s", opt.serviceName, url, err.Error())
 	}
@@ -119,14 +187,129 @@ func httpDo(opt *httpclientOption) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("http request failed, service name: %s, url: %s, error: %s", opt.serviceName, url, err.Error())
 	}
-	if resp.StatusCode >= http.StatusBadRequest {
-		return nil, fmt.Errorf("http request failed, service name: %s, url: %s, code: %d, status: %s", opt.serviceName, url, resp.StatusCode, resp.Status)
-	}
 	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	opt.respHeader = resp.Header
+	b, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("http request read body failed, service name: %s, url: %s, error: %s", opt.serviceName, url, err.Error())
 	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		// the error of the remote method, e.g. a NotFound written by WriteHttpError
+		return nil, decodeHttpError(resp.StatusCode, b)
+	}
 
 	return b, nil
 }
+
+// HttpRoute is the google.api.http binding a method is called by, the generated XxxHttpClient calls every method
+// by its first binding, or by the default route /package.Service/Method when it has none.
+type HttpRoute struct {
+	Name    string // full method name /package.Service/Method, it selects the [[client.method]] config
+	Method  string // http method, POST when empty
+	Pattern string // path template, e.g. /v1/{name=shelves/*}/books
+	Body    string // "*", a top level field name, or empty when the request has no body
+}
+
+// HttpInvoke calls the method of the http service by route and decodes the response into out,
+// the fields of in are bound to the path, the body and the query as the server binds them by BindHttpRequest.
+// The outgoing metadata of ctx and grpc.PerRPCCredentials are sent as http headers, grpc.Header and grpc.Trailer receive
+// the headers of the response, the other call options are ignored. The errors are status errors like the grpc client.
+func HttpInvoke(ctx context.Context, serviceName string, route HttpRoute, in, out proto.Message, mopts protojson.MarshalOptions, uopts protojson.UnmarshalOptions, opts ...grpc.CallOption) error {
+	uri, body, err := encodeHttpRequest(route, in, mopts)
+	if err != nil {
+		return err
+	}
+	headers, err := callHeaders(ctx, uri, opts)
+	if err != nil {
+		return err
+	}
+	opt := &httpclientOption{
+		method:      route.method(),
+		ctx:         ctx,
+		serviceName: serviceName,
+		uri:         uri,
+		name:        route.Name,
+		headers:     headers,
+	}
+	if body != nil {
+		opt.body = bytes.NewReader(body)
+	}
+	b, err := httpOperation(opt)
+	setCallHeader(opts, opt.respHeader)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if err := uopts.Unmarshal(b, out); err != nil {
+		return status.Errorf(codes.Internal, "decode response failed: %v", err)
+	}
+	return nil
+}
+
+func (r HttpRoute) method() string {
+	if r.Method == "" {
+		return "POST"
+	}
+	return r.Method
+}
+
+// callHeaders returns the outgoing metadata of ctx and the metadata of the grpc.PerRPCCredentials in opts as http headers,
+// binary values are base64 encoded as grpc does
+func callHeaders(ctx context.Context, uri string, opts []grpc.CallOption) (map[string]string, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for _, o := range opts {
+		if creds, ok := o.(grpc.PerRPCCredsCallOption); ok {
+			m, err := creds.Creds.GetRequestMetadata(ctx, uri)
+			if err != nil {
+				return nil, status.Errorf(codes.Unauthenticated, "get request metadata failed: %v", err)
+			}
+			for k, v := range m {
+				md.Set(k, v)
+			}
+		}
+	}
+	if len(md) == 0 {
+		return nil, nil
+	}
+	headers := make(map[string]string, len(md))
+	for k, vs := range md {
+		if strings.HasSuffix(k, "-bin") {
+			for i, v := range vs {
+				vs[i] = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+		}
+		headers[k] = strings.Join(vs, ",")
+	}
+	return headers, nil
+}
+
+// setCallHeader passes the headers of the response to the grpc.Header and grpc.Trailer in opts,
+// the trailers are always empty over http
+func setCallHeader(opts []grpc.CallOption, h http.Header) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = headerMetadata(h)
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = metadata.MD{}
+		}
+	}
+}
+
+func headerMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, vs := range h {
+		md.Append(k, vs...)
+	}
+	return md
+}
+
+// IsHttpClient reports whether the [[client]] named serviceName is configured with proto="http",
+// the generated NewXxxClientFor uses it to choose between the grpc and the http client.
+func IsHttpClient(serviceName string) bool {
+	cfg := getClientConfig(serviceName)
+	return cfg != nil && cfg.ProtoType == protoTypeHttp
+}
@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestHttpInvoke(t *testing.T) {
+	mux := NewHttpMux()
+	mux.HandleFunc("POST", "/pkg.Svc/Get", func(w http.ResponseWriter, req *http.Request) {
+		in := new(descriptorpb.FieldDescriptorProto)
+		if err := BindHttpRequest(req, in, "*", protojson.UnmarshalOptions{}); err != nil {
+			WriteHttpError(w, err)
+			return
+		}
+		if in.GetName() == "missing" {
+			WriteHttpError(w, status.Error(codes.NotFound, "field missing not found"))
+			return
+		}
+		b, _ := protojson.Marshal(&descriptorpb.FieldDescriptorProto{Name: proto.String(in.GetName()), Number: proto.Int32(7)})
+		w.Write(b)
+	})
+	mux.HandleFunc("POST", "/pkg.Svc/List", func(w http.ResponseWriter, req *http.Request) {
+		stream := NewHttpServerStream(w, req, protojson.MarshalOptions{})
+		for _, name := range []string{"a", "b"} {
+			stream.SendMsg(&descriptorpb.FieldDescriptorProto{Name: proto.String(name)})
+		}
+		stream.WriteError(status.Error(codes.Aborted, "stop"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	clientConfigMap["test_http"] = &clientConfig{
+		ServiceName:     "test_http",
+		ProtoType:       protoTypeHttp,
+		Timeout:         1000,
+		RetryTimes:      2,
+		RetryTimeout:    500,
+		EndpointStrList: []string{ts.URL},
+	}
+	defer delete(clientConfigMap, "test_http")
+	ctx := context.Background()
+	mopts, uopts := protojson.MarshalOptions{}, protojson.UnmarshalOptions{DiscardUnknown: true}
+
+	get := HttpRoute{Name: "/pkg.Svc/Get", Pattern: "/pkg.Svc/Get", Body: "*"}
+
+	out := new(descriptorpb.FieldDescriptorProto)
+	err := HttpInvoke(ctx, "test_http", get, &descriptorpb.FieldDescriptorProto{Name: proto.String("id")}, out, mopts, uopts)
+	if err != nil || out.GetName() != "id" || out.GetNumber() != 7 {
+		t.Errorf("HttpInvoke = %v, %v", out, err)
+	}
+
+	err = HttpInvoke(ctx, "test_http", get, &descriptorpb.FieldDescriptorProto{Name: proto.String("missing")}, out, mopts, uopts)
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "field missing not found" {
+		t.Errorf("HttpInvoke error = %v, want the NotFound of the server", err)
+	}
+
+	err = HttpInvoke(ctx, "test_http", HttpRoute{Name: "/pkg.Svc/Unknown", Pattern: "/pkg.Svc/Unknown", Body: "*"}, &descriptorpb.FieldDescriptorProto{}, out, mopts, uopts)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("HttpInvoke unknown route error = %v, want NotFound", err)
+	}
+
+	stream, err := NewHttpClientStream(ctx, "test_http", HttpRoute{Name: "/pkg.Svc/List", Pattern: "/pkg.Svc/List", Body: "*"}, &descriptorpb.FieldDescriptorProto{}, mopts, uopts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		m := new(descriptorpb.FieldDescriptorProto)
+		if err = stream.RecvMsg(m); err != nil {
+			break
+		}
+		names = append(names, m.GetName())
+	}
+	if strings.Join(names, ",") != "a,b" || status.Code(err) != codes.Aborted {
+		t.Errorf("stream received %v, %v, want a,b and Aborted", names, err)
+	}
+	if err == io.EOF {
+		t.Errorf("stream should end with the error of the server")
+	}
+}
+
+func TestHttpInvokeBinding(t *testing.T) {
+	mux := NewHttpMux()
+	mux.HandleFunc("GET", "/v1/{type_name=types/*}/fields/{name}", func(w http.ResponseWriter, req *http.Request) {
+		in := new(descriptorpb.FieldDescriptorProto)
+		if err := BindHttpRequest(req, in, "", protojson.UnmarshalOptions{}); err != nil {
+			WriteHttpError(w, err)
+			return
+		}
+		w.Header().Set("X-Served-By", req.Header.Get("X-User"))
+		b, _ := protojson.Marshal(in)
+		w.Write(b)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	clientConfigMap["test_http_binding"] = &clientConfig{
+		ServiceName:     "test_http_binding",
+		ProtoType:       protoTypeHttp,
+		Timeout:         1000,
+		EndpointStrList: []string{ts.URL},
+	}
+	defer delete(clientConfigMap, "test_http_binding")
+
+	in := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("a b/c"),
+		TypeName: proto.String("types/t"),
+		Number:   proto.Int32(3),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+		Options:  &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)},
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user", "u1")
+	route := HttpRoute{Name: "/pkg.Svc/GetField", Method: "GET", Pattern: "/v1/{type_name=types/*}/fields/{name}"}
+	out := new(descriptorpb.FieldDescriptorProto)
+	var header metadata.MD
+	err := HttpInvoke(ctx, "test_http_binding", route, in, out, protojson.MarshalOptions{}, protojson.UnmarshalOptions{}, grpc.Header(&header))
+	if err != nil || !proto.Equal(in, out) {
+		t.Errorf("HttpInvoke = %v, %v, want %v", out, err, in)
+	}
+	if got := header.Get("x-served-by"); len(got) != 1 || got[0] != "u1" {
+		t.Errorf("header of the response = %v, want the metadata of ctx sent as x-user", header)
+	}
+
+	err = HttpInvoke(ctx, "test_http_binding", route, &descriptorpb.FieldDescriptorProto{TypeName: proto.String("types/t")}, out, protojson.MarshalOptions{}, protojson.UnmarshalOptions{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("HttpInvoke with an empty path parameter = %v, want InvalidArgument", err)
+	}
+}
@@ -5,22 +5,15 @@ import (
 	"fmt"
 	"time"
 
-	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
-
 	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 
 	"google.golang.org/grpc"
 )
 
-var grpcPools map[string]*grpc.ClientConn
-
-func init() {
-	grpcPools = make(map[string]*grpc.ClientConn)
-}
-
-// DialService for rpc dial
-func DialService(ctx context.Context, serviceName string) (*grpc.ClientConn, error) {
+// DialService returns the handle of the grpc connection of the [[client]] named serviceName, the connection is dialed
+// by the first call and shared by the later ones, closing the handle does not close the shared connection.
+func DialService(ctx context.Context, serviceName string) (*ClientConn, error) {
 	conf := getClientConfig(serviceName)
 	if conf == nil {
 		return nil, ServiceConfigNotFound
@@ -30,33 +23,24 @@ func DialService(ctx context.Context, serviceName string) (*grpc.ClientConn, err
 		return nil, ServiceConfigInvalidProto
 	}
 
-	// TODO to optimize
-	if grpcPools[serviceName] != nil {
-		return grpcPools[serviceName], nil
-	}
-
-	opts := makeDialOption(conf)
-
-	var conn *grpc.ClientConn
-	var err error
-	switch conf.CallType {
-	case callTypeLocal:
-		conn, err = dialWithLocal(ctx, conf, opts...)
-	case callTypeConsul:
-		conn, err = dialWithConsul(ctx, conf, opts...)
-	default:
-		conn, err = dialWithLocal(ctx, conf, opts...)
-	}
+	e, err := grpcConns.get(ctx, conf)
 	if err != nil {
 		return nil, err
 	}
-	grpcPools[serviceName] = conn
-	return conn, nil
+	return &ClientConn{entry: e}, nil
 }
 
 func makeDialOption(conf *clientConfig) []grpc.DialOption {
+	retry := conf.retryPolicy()
+
+	// the timeout of the call includes the retries, the circuit breaker is outside the retries, the calls rejected by it are not retried
 	var streamInterceptorList []grpc.StreamClientInterceptor
-	var unaryInterceptorList []grpc.UnaryClientInterceptor
+	unaryInterceptorList := []grpc.UnaryClientInterceptor{timeoutUnaryClientInterceptor(conf)}
+	if conf.breaker != nil {
+		unaryInterceptorList = append(unaryInterceptorList, breakerUnaryClientInterceptor(conf.breaker))
+	}
+	streamInterceptorList = append(streamInterceptorList, retryStreamClientInterceptor(retry))
+	unaryInterceptorList = append(unaryInterceptorList, retryUnaryClientInterceptor(retry))
 	if GlobalConf.Metrics.Enabled {
 		streamInterceptorList = append(streamInterceptorList, grpc_prometheus.StreamClientInterceptor)
 		unaryInterceptorList = append(unaryInterceptorList, grpc_prometheus.UnaryClientInterceptor)
@@ -66,38 +50,43 @@ func makeDialOption(conf *clientConfig) []grpc.DialOption {
 		unaryInterceptorList = append(unaryInterceptorList, grpc_opentracing.UnaryClientInterceptor())
 	}
 
-	// retry times
-	opts := []grpc_retry.CallOption{
-		grpc_retry.WithMax(conf.RetryTimes),
-		grpc_retry.WithPerRetryTimeout(time.Duration(conf.RetryTimeout) * time.Millisecond),
-	}
-
 	return []grpc.DialOption{
 		grpc.WithChainStreamInterceptor(streamInterceptorList...),
 		grpc.WithChainUnaryInterceptor(unaryInterceptorList...),
-		grpc.WithStreamInterceptor(grpc_retry.StreamClientInterceptor(opts...)),
-		grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(opts...)),
 		grpc.WithInsecure(),
 	}
 }
 
-func dialWithConsul(ctx context.Context, cfg *clientConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+// dialWithRegistry resolves the endpoints by watching the registry, the requests are balanced by balance_type
+func dialWithRegistry(ctx context.Context, cfg *clientConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if globalRegistry == nil {
+		return nil, fmt.Errorf("dialWithRegistry, no registry for service %s", cfg.ServiceName)
+	}
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Millisecond)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, fmt.Sprintf("consul://%s:8500/%s", GlobalConf.Consul.Host, cfg.ServiceName), opts...)
+	opts = append(opts,
+		grpc.WithResolvers(&registryResolverBuilder{registry: globalRegistry, cfg: cfg}),
+		grpc.WithDefaultServiceConfig(grpcServiceConfig(cfg.BalanceType)),
+	)
+	conn, err := grpc.DialContext(ctx, fmt.Sprintf("%s:///%s", registryScheme, cfg.registryName()), opts...)
 	if err != nil {
-		return nil, fmt.Errorf("dialWithConsul, dial with context failed: %s", err.Error())
+		return nil, fmt.Errorf("dialWithRegistry, dial with context failed: %s", err.Error())
 	}
 
 	return conn, nil
 }
 
+// dialWithLocal resolves all the endpoints of the config, the requests are balanced by balance_type
 func dialWithLocal(ctx context.Context, cfg *clientConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Millisecond)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, cfg.endpointByBalancer(), opts...)
+	opts = append(opts,
+		grpc.WithResolvers(&staticResolverBuilder{cfg: cfg}),
+		grpc.WithDefaultServiceConfig(grpcServiceConfig(cfg.BalanceType)),
+	)
+	conn, err := grpc.DialContext(ctx, fmt.Sprintf("%s:///%s", staticScheme, cfg.ServiceName), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("dialWithLocal, dial with context failed: %s", err.Error())
 	}
@@ -1,11 +1,9 @@
 package rpc
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/creasty/defaults"
-
-	"github.com/BurntSushi/toml"
 )
 
 const (
@@ -14,22 +12,31 @@ const (
 )
 
 const (
-	callTypeConsul = "consul"
-	callTypeLocal  = "local"
+	callTypeConsul   = "consul"   // 通过注册中心发现，兼容之前的配置
+	callTypeRegistry = "registry" // 通过[registry]配置的注册中心发现
+	callTypeLocal    = "local"
 )
 
 const (
-	BalanceTypeRandom     = "random"
-	BalanceTypeRoundRobin = "roundrobin"
+	BalanceTypeRandom             = "random"
+	BalanceTypeRoundRobin         = "roundrobin"
+	BalanceTypeWeightedRoundRobin = "weighted_roundrobin" // 权重写在endpoints中，如 10.0.0.1:9900;w=3
+	BalanceTypeLeastRequest       = "least_request"       // 处理中请求最少的endpoint
+	BalanceTypeP2C                = "p2c"                 // 随机选两个endpoint，取处理中请求少的
+	BalanceTypeConsistentHash     = "consistent_hash"     // ketama一致性哈希，key由rpc.WithHashKey指定
 )
 
 type Config struct {
+	Env          string      `toml:"env"` // 环境名称，加载同目录下的rpc.{env}.toml覆盖rpc.toml，可以用AXE_ENV指定
 	Pprof        pprofConfig `toml:"pprof"`
 	Server       serverConfig
 	RateLimit    rateLimitConfig `toml:"rate_limit"`
 	Consul       consulConfig
+	Registry     registryConfig
 	Metrics      metricsConfig
 	Trace        traceConfig
+	Docs         docsConfig
+	Log          logConfig
 	RpcClients   []clientConfig `toml:"client"`
 	DBClients    []dbConfig     `toml:"database"`
 	RedisClients []redisConfig  `toml:"redis"`
@@ -40,13 +47,17 @@ type serverConfig struct {
 	Host        string `toml:"host"`
 	GrpcPort    int    `toml:"grpc_port"`
 	HttpPort    int    `toml:"http_port"`
+
+	ShutdownDelay   int `toml:"shutdown_delay" default:"0"`       // 退出时从consul注销后等待的时间(ms)，等待调用方更新节点列表
+	ShutdownTimeout int `toml:"shutdown_timeout" default:"10000"` // 等待处理中的请求结束的最长时间(ms)，必须大于0，超时后强制关闭连接
+	ReloadInterval  int `toml:"reload_interval" default:"5000"`   // 检查配置文件变化的间隔(ms)，0表示只在收到SIGHUP时重新加载
 }
 
 type rateLimitConfig struct {
 	Enabled      bool
 	Type         string `toml:"type" default:"always_pass"`
-	FillInterval int    `toml:"fill_interval" default:"300"`
-	Capacity     int64  `toml:"capacity" default:"3000"`
+	FillInterval int    `toml:"fill_interval" default:"300"` // no_block时每隔fill_interval(ms)放入一个token
+	Capacity     int64  `toml:"capacity" default:"3000"`     // 令牌桶的容量
 }
 
 type pprofConfig struct {
@@ -54,8 +65,29 @@ type pprofConfig struct {
 }
 
 type consulConfig struct {
-	Enabled bool
-	Host    string `toml:"host"`
+	Enabled          bool
+	Host             string            `toml:"host"`
+	Port             int               `toml:"port" default:"8500"`
+	Scheme           string            `toml:"scheme" default:"http"` // http或https
+	Datacenter       string            `toml:"datacenter"`
+	Token            string            `toml:"token"`             // ACL token
+	AdvertiseAddress string            `toml:"advertise_address"` // 注册到consul的ip，默认是[server] host，host为空或0.0.0.0时是本机第一个非loopback的ipv4地址
+	Tags             []string          `toml:"tags"`
+	Meta             map[string]string `toml:"meta"`
+	TLS              consulTLSConfig   `toml:"tls"`
+}
+
+type consulTLSConfig struct {
+	CAFile             string `toml:"ca_file"`
+	CertFile           string `toml:"cert_file"`
+	KeyFile            string `toml:"key_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+}
+
+type registryConfig struct {
+	Type            string `toml:"type" default:"consul"`           // 注册中心类型 consul或file
+	File            string `toml:"file"`                            // type为file时，记录各个服务endpoints的toml文件
+	RefreshInterval int    `toml:"refresh_interval" default:"5000"` // type为file时，检查文件变化的间隔(ms)
 }
 
 type metricsConfig struct {
@@ -63,6 +95,17 @@ type metricsConfig struct {
 	Type    string
 }
 
+// docsConfig serves the OpenAPI document of the http routes and the swagger ui on the http port
+type docsConfig struct {
+	Enabled bool
+	Path    string `toml:"path" default:"/docs"` // swagger ui的路径，OpenAPI文档在{path}/openapi.json
+	UIUrl   string `toml:"ui_url"`               // swagger-ui-dist静态资源的地址，为空时使用内嵌的静态资源
+}
+
+type logConfig struct {
+	Level string `toml:"level" default:"info"` // 日志级别 info或error，使用WithLogger时由自定义的logger处理
+}
+
 type traceConfig struct {
 	Enabled   bool
 	Type      string
@@ -72,15 +115,25 @@ type traceConfig struct {
 type clientConfig struct {
 	ServiceName  string `toml:"service_name"`
 	ProtoType    string `toml:"proto"`             // 协议名称 rpc或http
-	CallType     string `toml:"type"`              // 调用方式 consul或local
+	CallType     string `toml:"type"`              // 调用方式 consul(registry)或local
+	RegistryName string `toml:"registry_name"`     // 注册中心中的服务名，为空时rpc使用service_name，http使用service_name-http
 	Endpoints    string `toml:"endpoints"`         // 指定的调用ip端口，当type为local时使用
-	BalanceType  string `toml:"balance_type"`      // 负载均衡类型 round robin或random
+	BalanceType  string `toml:"balance_type"`      // 负载均衡类型 roundrobin、random、weighted_roundrobin、least_request、p2c或consistent_hash
 	Timeout      int    `toml:"timeout"`           // 超时时间，是总体的超时，包含多次重试后的超时
-	RetryTimes   uint   `toml:"retry_times"`       // 重试次数
+	RetryTimes   uint   `toml:"retry_times"`       // 最多调用的次数，包含第一次请求，0和1都只调用一次
 	RetryTimeout int    `toml:"per_retry_timeout"` // 每次调用(包含第一次请求)的超时
 
-	EndpointStrList []string  `toml:"-"`
-	Balancer        *Balancer `toml:"-"`
+	Outlier outlierConfig  `toml:"outlier"` // 摘除连续失败的endpoint
+	Breaker breakerConfig  `toml:"breaker"` // 熔断
+	Retry   retryConfig    `toml:"retry"`   // 重试策略
+	Methods []methodConfig `toml:"method"`  // 按方法设置超时和重试次数
+
+	EndpointStrList []string         `toml:"-"`
+	Picker          Picker           `toml:"-"`
+	outlier         *outlierDetector `toml:"-"`
+	breaker         *breakerGroup    `toml:"-"`
+	retry           *retryPolicy     `toml:"-"`
+	stopWatch       func()           `toml:"-"` // stops watching the endpoints in the registry
 }
 
 type redisConfig struct {
@@ -107,34 +160,36 @@ type dbConfig struct {
 
 var GlobalConf *Config
 
-func parseConfig(filePath string) *Config {
-	var cfg Config
-	if _, err := toml.DecodeFile(filePath, &cfg); err != nil {
-		log.Fatalf("parse config file failed, file path: %s, error: %v", filePath, err)
+// initConfig loads and validates the config file, GlobalConf is set when it is valid
+func initConfig(filePath string) (*Config, error) {
+	cfg, err := LoadConfig(filePath)
+	if err != nil {
+		return nil, err
 	}
-	return &cfg
-}
-
-func initConfig(filePath string) *Config {
-	GlobalConf = parseConfig(filePath)
-	setDefaultValue(GlobalConf)
-	return GlobalConf
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s, %s", filePath, err.Error())
+	}
+	GlobalConf = cfg
+	return cfg, nil
 }
 
 func setDefaultValue(cfg *Config) {
 	ds(cfg)
 	ds(&cfg.Server)
 	ds(&cfg.Consul)
+	ds(&cfg.Registry)
 	ds(&cfg.Metrics)
 	ds(&cfg.Trace)
-	for _, item := range cfg.RpcClients {
-		ds(&item)
+	ds(&cfg.Docs)
+	ds(&cfg.Log)
+	for i := range cfg.RpcClients {
+		ds(&cfg.RpcClients[i])
 	}
-	for _, item := range cfg.DBClients {
-		ds(&item)
+	for i := range cfg.DBClients {
+		ds(&cfg.DBClients[i])
 	}
-	for _, item := range cfg.RedisClients {
-		ds(&item)
+	for i := range cfg.RedisClients {
+		ds(&cfg.RedisClients[i])
 	}
 }
 
@@ -0,0 +1,391 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix is the prefix of the environment variables overriding the config, e.g. AXE_SERVER_GRPC_PORT is [server] grpc_port
+const envPrefix = "AXE_"
+
+const redacted = "******"
+
+// LoadConfig loads the config in layers and sets the default values, the config is not validated, see Validate:
+//  1. the file, e.g. rpc.toml
+//  2. the overlay of the env in the same directory, e.g. rpc.dev.toml, the env is AXE_ENV or env in the file,
+//     the tables are merged and the [[client]], [[database]] and [[redis]] with the same service_name are merged
+//  3. ${VAR}, ${VAR:-default} and ${file:path} in the strings are replaced by the environment variables and the contents of the files,
+//     a relative path is relative to the directory of the file
+//  4. the environment variables like AXE_SERVER_GRPC_PORT override the keys of the tables
+//
+// The keys are case insensitive like the fields of Config, e.g. [Server] of the file and [server] of the overlay are merged.
+func LoadConfig(filePath string) (*Config, error) {
+	tree, err := loadConfigTree(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(tree); err != nil {
+		return nil, fmt.Errorf("merge config file failed, file path: %s, error: %v", filePath, err)
+	}
+	var cfg Config
+	if _, err := toml.Decode(buf.String(), &cfg); err != nil {
+		return nil, fmt.Errorf("parse config file failed, file path: %s, error: %v", filePath, err)
+	}
+	setDefaultValue(&cfg)
+	return &cfg, nil
+}
+
+func loadConfigTree(filePath string) (map[string]interface{}, error) {
+	tree, err := decodeConfigFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	env := os.Getenv(envPrefix + "ENV")
+	if env == "" {
+		env, _ = tree[lookupKey(tree, "env")].(string)
+	}
+	if env != "" {
+		path := overlayPath(filePath, env)
+		if _, err := os.Stat(path); err == nil {
+			overlay, err := decodeConfigFile(path)
+			if err != nil {
+				return nil, err
+			}
+			mergeTree(tree, overlay)
+		}
+	}
+
+	if err := interpolateTree(tree, "", filepath.Dir(filePath)); err != nil {
+		return nil, fmt.Errorf("parse config file failed, file path: %s, error: %v", filePath, err)
+	}
+	if err := overrideTree(tree, os.Environ()); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func decodeConfigFile(path string) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	if _, err := toml.DecodeFile(path, &tree); err != nil {
+		return nil, fmt.Errorf("parse config file failed, file path: %s, error: %v", path, err)
+	}
+	return tree, nil
+}
+
+// overlayPath is the file of env next to the file, e.g. conf/rpc.dev.toml of conf/rpc.toml
+func overlayPath(filePath, env string) string {
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + "." + env + ext
+}
+
+// mergeTree merges overlay into tree, the tables are merged recursively, the items of the arrays of tables are merged
+// by service_name and the other values are replaced
+func mergeTree(tree, overlay map[string]interface{}) {
+	for k, v := range overlay {
+		k = lookupKey(tree, k)
+		switch ov := v.(type) {
+		case map[string]interface{}:
+			if tv, ok := tree[k].(map[string]interface{}); ok {
+				mergeTree(tv, ov)
+				continue
+			}
+		case []map[string]interface{}:
+			if tv, ok := tree[k].([]map[string]interface{}); ok {
+				tree[k] = mergeTables(tv, ov)
+				continue
+			}
+		}
+		tree[k] = v
+	}
+}
+
+func mergeTables(tables, overlay []map[string]interface{}) []map[string]interface{} {
+	for _, o := range overlay {
+		name, ok := o[lookupKey(o, "service_name")].(string)
+		merged := false
+		for _, t := range tables {
+			if ok && t[lookupKey(t, "service_name")] == name {
+				mergeTree(t, o)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			tables = append(tables, o)
+		}
+	}
+	return tables
+}
+
+// lookupKey returns the key of tree equal to key ignoring case, or key if there is none,
+// so that the keys differing in case are one key as they are decoded into Config
+func lookupKey(tree map[string]interface{}, key string) string {
+	if _, ok := tree[key]; ok {
+		return key
+	}
+	for k := range tree {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+var interpolation = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// interpolateTree replaces ${VAR}, ${VAR:-default} and ${file:path} in the strings of the tree, path is the toml path of the tree
+// and the relative file paths are relative to dir
+func interpolateTree(v interface{}, path, dir string) error {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		// in the order of the keys, so that the error is the same for the same file
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			item := tv[k]
+			if s, ok := item.(string); ok {
+				var err error
+				if tv[k], err = interpolate(s, joinPath(path, k), dir); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := interpolateTree(item, joinPath(path, k), dir); err != nil {
+				return err
+			}
+		}
+	case []map[string]interface{}:
+		for i, item := range tv {
+			if err := interpolateTree(item, fmt.Sprintf("%s[%d]", path, i), dir); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range tv {
+			if s, ok := item.(string); ok {
+				var err error
+				if tv[i], err = interpolate(s, fmt.Sprintf("%s[%d]", path, i), dir); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func interpolate(s, path, dir string) (string, error) {
+	var err error
+	result := interpolation.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if file := strings.TrimPrefix(name, "file:"); file != name {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			b, rerr := ioutil.ReadFile(file)
+			if rerr != nil {
+				err = fmt.Errorf("%s: read %s failed, error: %v", path, file, rerr)
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		}
+		def := ""
+		hasDef := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDef = name[:i], name[i+2:], true
+		}
+		value, ok := os.LookupEnv(name)
+		switch {
+		case ok:
+			return value
+		case hasDef:
+			return def
+		}
+		err = fmt.Errorf("%s: environment variable %s is not set", path, name)
+		return ""
+	})
+	return result, err
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// envKey is a key of the config overridden by an environment variable
+type envKey struct {
+	path []string
+	typ  reflect.Type
+}
+
+// envKeys maps the environment variables to the keys of the tables of Config, the arrays of tables are not overridden
+func envKeys() map[string]envKey {
+	keys := make(map[string]envKey)
+	var walk func(t reflect.Type, path []string)
+	walk = func(t reflect.Type, path []string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := tomlKey(f)
+			if name == "" {
+				continue
+			}
+			p := append(append([]string{}, path...), name)
+			switch {
+			case f.Type.Kind() == reflect.Struct:
+				walk(f.Type, p)
+			case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct, f.Type.Kind() == reflect.Map:
+			default:
+				keys[envPrefix+strings.ToUpper(strings.Join(p, "_"))] = envKey{path: p, typ: f.Type}
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil)
+	return keys
+}
+
+// tomlKey is the key of a field in the toml file, empty for the fields not in the file
+func tomlKey(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := strings.Split(f.Tag.Get("toml"), ",")[0]
+	switch tag {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	}
+	return tag
+}
+
+// overrideTree sets the keys of the environment variables with envPrefix, the values are converted to the types of the keys,
+// the lists are separated by commas
+func overrideTree(tree map[string]interface{}, environ []string) error {
+	keys := envKeys()
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		key, ok := keys[kv[:i]]
+		if !ok {
+			continue
+		}
+		value, err := envValue(kv[i+1:], key.typ)
+		if err != nil {
+			return fmt.Errorf("invalid environment variable %s, error: %v", kv[:i], err)
+		}
+		t := tree
+		for _, name := range key.path[:len(key.path)-1] {
+			name = lookupKey(t, name)
+			sub, ok := t[name].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				t[name] = sub
+			}
+			t = sub
+		}
+		t[lookupKey(t, key.path[len(key.path)-1])] = value
+	}
+	return nil
+}
+
+func envValue(s string, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	case reflect.Slice:
+		list := []interface{}{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				v, err := envValue(item, t.Elem())
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+		}
+		return list, nil
+	}
+	return s, nil
+}
+
+// Dump writes the effective config in toml, including the default values, the passwords, tokens and secrets are redacted
+func (cfg *Config) Dump(w io.Writer) error {
+	tree, _ := configTree(reflect.ValueOf(*cfg)).(map[string]interface{})
+	return toml.NewEncoder(w).Encode(tree)
+}
+
+// configTree converts the config to the toml tree, the empty lists and maps are omitted
+func configTree(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		tree := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			name := tomlKey(v.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			if item := configTree(v.Field(i)); item != nil {
+				tree[name] = redact(name, item)
+			}
+		}
+		return tree
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return v.Interface()
+		}
+		tables := make([]map[string]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			tables = append(tables, configTree(v.Index(i)).(map[string]interface{}))
+		}
+		return tables
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+	}
+	return v.Interface()
+}
+
+func redact(name string, v interface{}) interface{} {
+	if s, ok := v.(string); ok && s != "" && isSecretKey(name) {
+		return redacted
+	}
+	return v
+}
+
+// isSecretKey reports whether the key is a secret, e.g. password of [[redis]] or token of [consul]
+func isSecretKey(name string) bool {
+	for _, s := range []string{"password", "token", "secret"} {
+		if name == s || strings.HasSuffix(name, "_"+s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, config := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "rpc.toml")
+}
+
+const overlayBaseConfig = `
+env = "dev"
+
+[server]
+service_name = "test"
+grpc_port = 9900
+
+[[client]]
+service_name = "a"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8001"
+timeout = 1000
+`
+
+func TestLoadConfigOverlay(t *testing.T) {
+	path := writeConfigFiles(t, map[string]string{
+		"rpc.toml": overlayBaseConfig,
+		"rpc.dev.toml": `
+[server]
+grpc_port = 9910
+
+[[client]]
+service_name = "a"
+endpoints = "127.0.0.1:8002"
+
+[[client]]
+service_name = "b"
+proto = "rpc"
+type = "local"
+endpoints = "127.0.0.1:9000"
+`,
+		"rpc.prod.toml": `
+[server]
+grpc_port = 9920
+`,
+	})
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.GrpcPort != 9910 || cfg.Server.ServiceName != "test" {
+		t.Errorf("[server] = %+v, want grpc_port of the overlay and service_name of the base", cfg.Server)
+	}
+	if len(cfg.RpcClients) != 2 {
+		t.Fatalf("clients = %+v, want a merged and b appended", cfg.RpcClients)
+	}
+	if a := cfg.RpcClients[0]; a.Endpoints != "127.0.0.1:8002" || a.Timeout != 1000 || a.ProtoType != protoTypeHttp {
+		t.Errorf("merged client = %+v", a)
+	}
+
+	t.Setenv("AXE_ENV", "prod")
+	if cfg, err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.GrpcPort != 9920 || len(cfg.RpcClients) != 1 {
+		t.Errorf("config of AXE_ENV=prod = %+v, %d clients", cfg.Server, len(cfg.RpcClients))
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	path := writeConfigFiles(t, map[string]string{
+		"rpc.toml": `
+[server]
+service_name = "${AXE_TEST_SERVICE:-test}"
+
+[consul]
+token = "${file:` + filepath.Join(t.TempDir(), "missing") + `}"
+
+[[redis]]
+service_name = "cache"
+address = "${AXE_TEST_REDIS_HOST}:6379"
+password = "${file:secrets/redis_password}"
+`,
+	})
+	// the relative path is relative to the directory of the config file, not the working directory
+	dir := filepath.Join(filepath.Dir(path), "secrets")
+	os.Mkdir(dir, 0700)
+	ioutil.WriteFile(filepath.Join(dir, "redis_password"), []byte("s3cret\n"), 0600)
+
+	// a missing file is an error
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "consul.token") {
+		t.Errorf("LoadConfig with a missing secret file = %v", err)
+	}
+	ioutil.WriteFile(path, bytes.Replace(mustRead(t, path), []byte("token = "), []byte("# token = "), 1), 0644)
+
+	// an unset variable without default is an error
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "AXE_TEST_REDIS_HOST") {
+		t.Errorf("LoadConfig with an unset variable = %v", err)
+	}
+
+	t.Setenv("AXE_TEST_REDIS_HOST", "10.0.0.1")
+	t.Setenv("AXE_SERVER_GRPC_PORT", "9930")
+	t.Setenv("AXE_RATE_LIMIT_ENABLED", "true")
+	t.Setenv("AXE_CONSUL_TAGS", "a, b")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.ServiceName != "test" || cfg.Server.GrpcPort != 9930 || !cfg.RateLimit.Enabled || !reflect.DeepEqual(cfg.Consul.Tags, []string{"a", "b"}) {
+		t.Errorf("config = %+v, %+v, %+v", cfg.Server, cfg.RateLimit, cfg.Consul)
+	}
+	if r := cfg.RedisClients[0]; r.Address != "10.0.0.1:6379" || r.Password != "s3cret" {
+		t.Errorf("redis = %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "s3cret") || !strings.Contains(buf.String(), "grpc_port = 9930") {
+		t.Errorf("dump =\n%s", buf.String())
+	}
+
+	t.Setenv("AXE_SERVER_GRPC_PORT", "x")
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("LoadConfig with an invalid AXE_SERVER_GRPC_PORT succeeded")
+	}
+}
+
+func TestLoadConfigKeyCase(t *testing.T) {
+	path := writeConfigFiles(t, map[string]string{
+		"rpc.toml": `
+[Server]
+service_name = "test"
+GRPC_PORT = 9900
+
+[[Client]]
+Service_Name = "a"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8001"
+`,
+		"rpc.dev.toml": `
+[server]
+http_port = 9901
+
+[[client]]
+service_name = "a"
+endpoints = "127.0.0.1:8002"
+`,
+	})
+	t.Setenv("AXE_ENV", "dev")
+	t.Setenv("AXE_SERVER_GRPC_PORT", "9910")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cfg.Server; s.ServiceName != "test" || s.GrpcPort != 9910 || s.HttpPort != 9901 {
+		t.Errorf("[server] = %+v, want [Server] merged with the overlay and AXE_SERVER_GRPC_PORT", s)
+	}
+	if len(cfg.RpcClients) != 1 || cfg.RpcClients[0].Endpoints != "127.0.0.1:8002" || cfg.RpcClients[0].ProtoType != protoTypeHttp {
+		t.Errorf("clients = %+v, want [[Client]] merged with the overlay", cfg.RpcClients)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigError is a problem of the config at a toml path, e.g. client[1].endpoints
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ConfigErrors are all the problems of the config found by Validate
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d problems in config", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type configChecker struct {
+	errs ConfigErrors
+}
+
+func (c *configChecker) add(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// oneOf checks that value is one of the valid values
+func (c *configChecker) oneOf(path, value string, valid ...string) {
+	for _, v := range valid {
+		if value == v {
+			return
+		}
+	}
+	c.add(path, "unknown value %q, want one of %s", value, strings.Join(valid, ", "))
+}
+
+func (c *configChecker) nonNegative(path string, value int) {
+	if value < 0 {
+		c.add(path, "must not be negative, got %d", value)
+	}
+}
+
+// unique checks that name is not used by the items before in the array of tables
+func (c *configChecker) unique(seen map[string]int, path, name string, i int) {
+	if name == "" {
+		c.add(path, "is empty")
+		return
+	}
+	if j, ok := seen[name]; ok {
+		c.add(path, "duplicate %q, also at index %d", name, j)
+		return
+	}
+	seen[name] = i
+}
+
+// Validate checks the config after the default values are set, it returns ConfigErrors listing every problem with its toml path,
+// or nil when the config is valid. NewServer and Reload reject an invalid config.
+func (cfg *Config) Validate() error {
+	c := &configChecker{}
+
+	if cfg.Server.ServiceName == "" {
+		c.add("server.service_name", "is empty")
+	}
+	c.nonNegative("server.grpc_port", cfg.Server.GrpcPort)
+	c.nonNegative("server.http_port", cfg.Server.HttpPort)
+	c.nonNegative("server.shutdown_delay", cfg.Server.ShutdownDelay)
+	c.nonNegative("server.shutdown_timeout", cfg.Server.ShutdownTimeout)
+	c.nonNegative("server.reload_interval", cfg.Server.ReloadInterval)
+
+	if cfg.RateLimit.Enabled {
+		c.oneOf("rate_limit.type", cfg.RateLimit.Type, LimiterAlwaysPass, LimiterNoBlock)
+		if cfg.RateLimit.Type == LimiterNoBlock {
+			if cfg.RateLimit.FillInterval <= 0 {
+				c.add("rate_limit.fill_interval", "must be positive, got %d", cfg.RateLimit.FillInterval)
+			}
+			if cfg.RateLimit.Capacity <= 0 {
+				c.add("rate_limit.capacity", "must be positive, got %d", cfg.RateLimit.Capacity)
+			}
+		}
+	}
+
+	c.oneOf("registry.type", cfg.Registry.Type, registryTypeConsul, registryTypeFile)
+	if cfg.Registry.Type == registryTypeFile && cfg.Registry.File == "" {
+		c.add("registry.file", "is empty, it is required by registry type file")
+	}
+	c.oneOf("log.level", cfg.Log.Level, "info", "error")
+	if cfg.Docs.Enabled && (!strings.HasPrefix(cfg.Docs.Path, "/") || strings.Trim(cfg.Docs.Path, "/") == "") {
+		c.add("docs.path", "must start with / and not be the root, got %q", cfg.Docs.Path)
+	}
+
+	names := make(map[string]int)
+	for i := range cfg.RpcClients {
+		c.checkClient(fmt.Sprintf("client[%d]", i), &cfg.RpcClients[i], names, i)
+	}
+	names = make(map[string]int)
+	for i, item := range cfg.DBClients {
+		c.unique(names, fmt.Sprintf("database[%d].service_name", i), item.ServiceName, i)
+	}
+	names = make(map[string]int)
+	for i, item := range cfg.RedisClients {
+		path := fmt.Sprintf("redis[%d]", i)
+		c.unique(names, path+".service_name", item.ServiceName, i)
+		if item.Address == "" {
+			c.add(path+".address", "is empty")
+		}
+	}
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+func (c *configChecker) checkClient(path string, item *clientConfig, names map[string]int, i int) {
+	c.unique(names, path+".service_name", item.ServiceName, i)
+	c.oneOf(path+".proto", item.ProtoType, protoTypeRpc, protoTypeHttp)
+	c.oneOf(path+".type", item.CallType, callTypeConsul, callTypeRegistry, callTypeLocal)
+	if item.CallType == callTypeLocal {
+		if strings.TrimSpace(item.Endpoints) == "" {
+			c.add(path+".endpoints", "is empty, it is required by type local")
+		} else {
+			for _, ep := range strings.Split(item.Endpoints, ",") {
+				if addr, _ := parseEndpoint(ep); addr == "" {
+					c.add(path+".endpoints", "empty endpoint in %q", item.Endpoints)
+					break
+				}
+			}
+		}
+	}
+	if item.BalanceType != "" {
+		c.oneOf(path+".balance_type", item.BalanceType, BalanceTypeRoundRobin, BalanceTypeRandom, BalanceTypeWeightedRoundRobin,
+			BalanceTypeLeastRequest, BalanceTypeP2C, BalanceTypeConsistentHash)
+	}
+	c.nonNegative(path+".timeout", item.Timeout)
+	c.nonNegative(path+".per_retry_timeout", item.RetryTimeout)
+
+	for _, name := range item.Retry.Codes {
+		if _, err := parseCode(name); err != nil {
+			c.add(path+".retry.codes", "unknown grpc code %q", name)
+		}
+	}
+	if item.Retry.Jitter < 0 || item.Retry.Jitter > 1 {
+		c.add(path+".retry.jitter", "must be in [0, 1], got %v", item.Retry.Jitter)
+	}
+	if item.Breaker.Enabled && (item.Breaker.FailureRatio <= 0 || item.Breaker.FailureRatio > 1) {
+		c.add(path+".breaker.failure_ratio", "must be in (0, 1], got %v", item.Breaker.FailureRatio)
+	}
+	if item.Outlier.Enabled && (item.Outlier.MaxEjectionPercent < 0 || item.Outlier.MaxEjectionPercent > 100) {
+		c.add(path+".outlier.max_ejection_percent", "must be in [0, 100], got %d", item.Outlier.MaxEjectionPercent)
+	}
+
+	methods := make(map[string]int)
+	for j, m := range item.Methods {
+		mpath := fmt.Sprintf("%s.method[%d]", path, j)
+		c.unique(methods, mpath+".name", m.Name, j)
+		c.nonNegative(mpath+".timeout", m.Timeout)
+		c.nonNegative(mpath+".per_retry_timeout", m.RetryTimeout)
+	}
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, config string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rpc.toml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := loadTestConfig(t, `
+[server]
+service_name = "test"
+
+[rate_limit]
+enabled = true
+type = "token_bucket"
+
+[docs]
+enabled = true
+path = "/"
+
+[[client]]
+service_name = "a"
+proto = "grpc"
+type = "local"
+
+[[client]]
+service_name = "a"
+proto = "http"
+type = "dns"
+balance_type = "rr"
+
+[client.retry]
+codes = ["UNAVAILABLE", "TIMEOUT"]
+
+[[client.method]]
+name = "/a"
+
+[[client.method]]
+name = "/a"
+
+[[redis]]
+service_name = "cache"
+`)
+	err := cfg.Validate()
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Validate() = %v, want ConfigErrors", err)
+	}
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	want := []string{
+		"rate_limit.type",
+		"docs.path",
+		"client[0].proto",
+		"client[0].endpoints",
+		"client[1].service_name",
+		"client[1].type",
+		"client[1].balance_type",
+		"client[1].retry.codes",
+		"client[1].method[1].name",
+		"redis[0].address",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths of the problems = %v, want %v", paths, want)
+	}
+}
+
+func TestConfigDefaultsOfArrays(t *testing.T) {
+	cfg := loadTestConfig(t, `
+[server]
+service_name = "test"
+
+[[client]]
+service_name = "a"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8001"
+
+[[database]]
+service_name = "db"
+`)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.RpcClients[0]; c.Retry.BackoffBase != 50 || len(c.Retry.Codes) != 2 || c.Breaker.FailureRatio != 0.5 {
+		t.Errorf("defaults of [[client]] not set: %+v", c.Retry)
+	}
+	if port := cfg.DBClients[0].Port; port != 3306 {
+		t.Errorf("port of [[database]] = %d, want default 3306", port)
+	}
+}
+
+func TestNewServerInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.toml")
+	ioutil.WriteFile(path, []byte("[server]\nservice_name = \"test\"\n[log]\nlevel = \"debug\"\n"), 0644)
+	if _, err := NewServer(path); err == nil {
+		t.Errorf("NewServer with an invalid config succeeded")
+	}
+}
@@ -0,0 +1,252 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClientConnClosed is returned by the calls of a ClientConn after its Close
+var ErrClientConnClosed = status.Error(codes.Canceled, "client connection is closed")
+
+var connState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "axe_client_connection_state",
+	Help: "Connectivity state of the grpc connections of the clients, 0 idle, 1 connecting, 2 ready, 3 transient failure, 4 shutdown.",
+}, []string{"service"})
+
+func init() {
+	prometheus.MustRegister(connState)
+}
+
+// grpcConns holds one grpc connection for each [[client]], shared by all the callers of DialService
+var grpcConns = &connManager{conns: make(map[string]*connEntry)}
+
+// ClientConn is returned by DialService, it is a handle of the connection shared by all the callers of the service.
+// Close releases the handle only, the shared connection is closed when the server shuts down and is redialed when the config
+// of the client changes. It implements grpc.ClientConnInterface so that it is passed to the generated NewXxxClient.
+type ClientConn struct {
+	entry  *connEntry
+	closed int32
+}
+
+var _ grpc.ClientConnInterface = (*ClientConn)(nil)
+
+// Invoke performs a unary rpc on the shared connection
+func (c *ClientConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	conn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream creates a stream on the shared connection
+func (c *ClientConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+// GetState returns the connectivity state of the shared connection
+func (c *ClientConn) GetState() connectivity.State {
+	conn, err := c.conn()
+	if err != nil {
+		return connectivity.Shutdown
+	}
+	return conn.GetState()
+}
+
+// Close releases the handle, the calls after it fail with ErrClientConnClosed, the shared connection is kept for the other callers
+func (c *ClientConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *ClientConn) conn() (*grpc.ClientConn, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrClientConnClosed
+	}
+	return c.entry.current()
+}
+
+// connManager dials each service once, the concurrent first callers wait for the same dial
+type connManager struct {
+	mu    sync.Mutex
+	conns map[string]*connEntry
+}
+
+type connEntry struct {
+	serviceName string
+	ready       chan struct{} // closed when the first dial is done
+	err         error         // the error of the first dial
+
+	mu     sync.RWMutex
+	cfg    *clientConfig // the config conn is dialed with
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc // stops the monitor of conn
+	closed bool
+}
+
+// get returns the connection of cfg, it is dialed by the first caller and redialed when cfg is not the config it is dialed with.
+// A failed dial is not cached, the next caller dials again.
+func (m *connManager) get(ctx context.Context, cfg *clientConfig) (*connEntry, error) {
+	m.mu.Lock()
+	e, ok := m.conns[cfg.ServiceName]
+	if !ok {
+		e = &connEntry{serviceName: cfg.ServiceName, ready: make(chan struct{})}
+		m.conns[cfg.ServiceName] = e
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		e.err = e.dial(ctx, cfg)
+		if e.err != nil {
+			m.mu.Lock()
+			if m.conns[cfg.ServiceName] == e {
+				delete(m.conns, cfg.ServiceName)
+			}
+			m.mu.Unlock()
+		}
+		close(e.ready)
+	}
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	if err := e.dial(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// refresh redials the connections whose [[client]] config is changed, e.g. the endpoints of a local client.
+// The connections of the registry clients follow the endpoints by the resolver.
+func (m *connManager) refresh() {
+	m.mu.Lock()
+	entries := make([]*connEntry, 0, len(m.conns))
+	for _, e := range m.conns {
+		entries = append(entries, e)
+	}
+	m.mu.Unlock()
+
+	for _, e := range entries {
+		select {
+		case <-e.ready:
+		default:
+			// the first dial is in progress, it uses the current config
+			continue
+		}
+		cfg := getClientConfig(e.serviceName)
+		if e.err != nil || cfg == nil || cfg.ProtoType != protoTypeRpc {
+			continue
+		}
+		if err := e.dial(context.Background(), cfg); err != nil {
+			gLogger.Error("redial %s failed, error: %s", e.serviceName, err.Error())
+		}
+	}
+}
+
+// closeAll closes the shared connections, the handles fail after it
+func (m *connManager) closeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, e := range m.conns {
+		e.close()
+		delete(m.conns, name)
+	}
+}
+
+func (e *connEntry) current() (*grpc.ClientConn, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed || e.conn == nil {
+		return nil, ErrClientConnClosed
+	}
+	return e.conn, nil
+}
+
+// dial connects with cfg unless the connection is dialed with it, the replaced connection is closed after the timeout
+// of the client, so that the calls in flight on it can finish.
+func (e *connEntry) dial(ctx context.Context, cfg *clientConfig) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClientConnClosed
+	}
+	if e.cfg == cfg {
+		return nil
+	}
+
+	conn, err := dialConfig(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	old, oldCfg, oldCancel := e.conn, e.cfg, e.cancel
+
+	mctx, cancel := context.WithCancel(context.Background())
+	e.conn, e.cfg, e.cancel = conn, cfg, cancel
+	go e.monitor(mctx, conn)
+
+	if old != nil {
+		gLogger.Info("config of %s changed, reconnect", e.serviceName)
+		oldCancel()
+		time.AfterFunc(time.Duration(oldCfg.Timeout)*time.Millisecond, func() {
+			old.Close()
+		})
+	}
+	return nil
+}
+
+func (e *connEntry) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	if e.conn != nil {
+		e.cancel()
+		e.conn.Close()
+	}
+}
+
+// monitor logs the state changes of conn until ctx is done, grpc reconnects with backoff in the transient failure state
+func (e *connEntry) monitor(ctx context.Context, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for {
+		connState.WithLabelValues(e.serviceName).Set(float64(state))
+		if !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		last := state
+		state = conn.GetState()
+		switch {
+		case state == connectivity.TransientFailure:
+			gLogger.Error("connection of %s is %s, reconnecting", e.serviceName, state)
+		case last == connectivity.TransientFailure && state == connectivity.Ready:
+			gLogger.Info("connection of %s is ready again", e.serviceName)
+		}
+	}
+}
+
+// dialConfig dials by the call type of cfg, the connection is not blocked until it is ready
+func dialConfig(ctx context.Context, cfg *clientConfig) (*grpc.ClientConn, error) {
+	opts := makeDialOption(cfg)
+	switch cfg.CallType {
+	case callTypeConsul, callTypeRegistry:
+		return dialWithRegistry(ctx, cfg, opts...)
+	default:
+		return dialWithLocal(ctx, cfg, opts...)
+	}
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func setConnTestClient(t *testing.T, cfg *clientConfig) {
+	if GlobalConf == nil {
+		GlobalConf = &Config{}
+		t.Cleanup(func() { GlobalConf = nil })
+	}
+	clientConfigMap[cfg.ServiceName] = cfg
+	t.Cleanup(func() {
+		delete(clientConfigMap, cfg.ServiceName)
+		grpcConns.mu.Lock()
+		e := grpcConns.conns[cfg.ServiceName]
+		delete(grpcConns.conns, cfg.ServiceName)
+		grpcConns.mu.Unlock()
+		if e != nil {
+			e.close()
+		}
+	})
+}
+
+func checkHealth(t *testing.T, conn grpc.ClientConnInterface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	return err
+}
+
+func TestDialServiceShared(t *testing.T) {
+	addrs, counts := startCountingServers(t, 1)
+	setConnTestClient(t, &clientConfig{
+		ServiceName: "conn_test",
+		ProtoType:   protoTypeRpc,
+		CallType:    callTypeLocal,
+		Endpoints:   addrs[0],
+		Timeout:     1000,
+	})
+
+	var wg sync.WaitGroup
+	handles := make([]*ClientConn, 20)
+	for i := range handles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := DialService(context.Background(), "conn_test")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			handles[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	shared, _ := handles[0].entry.current()
+	for _, h := range handles {
+		if conn, _ := h.entry.current(); conn != shared {
+			t.Fatal("concurrent DialService dialed more than one connection")
+		}
+	}
+
+	// closing a handle keeps the shared connection for the others
+	handles[0].Close()
+	if err := checkHealth(t, handles[0]); err != ErrClientConnClosed {
+		t.Errorf("call after Close = %v, want ErrClientConnClosed", err)
+	}
+	if err := checkHealth(t, handles[1]); err != nil {
+		t.Errorf("call with another handle = %v", err)
+	}
+	if n := atomic.LoadInt64(counts[0]); n != 1 {
+		t.Errorf("server served %d requests, want 1", n)
+	}
+}
+
+func TestDialServiceRefresh(t *testing.T) {
+	addrs, counts := startCountingServers(t, 2)
+	cfg := &clientConfig{
+		ServiceName: "conn_refresh_test",
+		ProtoType:   protoTypeRpc,
+		CallType:    callTypeLocal,
+		Endpoints:   addrs[0],
+		Timeout:     1000,
+	}
+	setConnTestClient(t, cfg)
+
+	conn, err := DialService(context.Background(), cfg.ServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := checkHealth(t, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the endpoints change, the handle follows the redialed connection
+	changed := *cfg
+	changed.Endpoints = addrs[1]
+	clientConfigMap[cfg.ServiceName] = &changed
+	grpcConns.refresh()
+	if err := checkHealth(t, conn); err != nil {
+		t.Fatal(err)
+	}
+	if n0, n1 := atomic.LoadInt64(counts[0]), atomic.LoadInt64(counts[1]); n0 != 1 || n1 != 1 {
+		t.Errorf("servers served %d, %d requests, want 1, 1", n0, n1)
+	}
+}
@@ -1,107 +1,247 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
-	_ "github.com/mbobakov/grpc-consul-resolver"
-)
-
-var (
-	consulAgent     *api.Agent
-	consulServiceID string
-	consulCheckID   string
 )
 
 const (
 	defaultConsulHost = "127.0.0.1"
+	defaultConsulPort = 8500
 	defaultInterval   = 10 * time.Second
 	defaultTTL        = 30 * time.Second
 	defaultTimeout    = 2 * time.Second
 )
 
-func registerConsul(cfg *Config) error {
+// consulRegistry registers the instances to the local consul agent with a ttl check driven by the readiness probes,
+// and discovers the passing instances by blocking queries.
+type consulRegistry struct {
+	cfg consulConfig
+	// 只有非dev环境，并且开关打开，才执行consul的注册
+	register bool
+
+	// the client is created on the first use, so that a server neither registering nor discovering by consul never dials it
+	clientOnce sync.Once
+	client     *api.Client
+	clientErr  error
+
+	mu       sync.Mutex
+	checkIDs []string
+}
+
+func newConsulRegistry(cfg *Config) (*consulRegistry, error) {
 	if cfg.Consul.Host == "" {
 		cfg.Consul.Host = defaultConsulHost
 	}
-	cc := api.DefaultConfig()
-	cc.Address = fmt.Sprintf("%s:8500", cfg.Consul.Host)
-	client, err := api.NewClient(cc)
-	if err != nil {
-		return fmt.Errorf("waring: create consul client error: %v", err)
+	if cfg.Consul.Port == 0 {
+		cfg.Consul.Port = defaultConsulPort
 	}
-
-	agentIP := GetLocalIP()
-	if agentIP == "" {
-		return fmt.Errorf("warning: init consul failed, get local ip empty")
+	r := &consulRegistry{cfg: cfg.Consul, register: cfg.Consul.Enabled}
+	if r.register {
+		// fail fast on an invalid consul config when the server registers itself
+		if _, err := r.getClient(); err != nil {
+			return nil, err
+		}
 	}
+	return r, nil
+}
 
-	consulAgent = client.Agent()
-	consulServiceID = fmt.Sprintf("%s-%d", agentIP, cfg.Server.GrpcPort)
-	consulCheckID = consulServiceID + "-ttl"
+// getClient returns the consul client, it is created by the first call
+func (r *consulRegistry) getClient() (*api.Client, error) {
+	r.clientOnce.Do(func() {
+		cc := api.DefaultConfig()
+		cc.Address = fmt.Sprintf("%s:%d", r.cfg.Host, r.cfg.Port)
+		cc.Scheme = r.cfg.Scheme
+		cc.Datacenter = r.cfg.Datacenter
+		cc.Token = r.cfg.Token
+		cc.TLSConfig = api.TLSConfig{
+			CAFile:             r.cfg.TLS.CAFile,
+			CertFile:           r.cfg.TLS.CertFile,
+			KeyFile:            r.cfg.TLS.KeyFile,
+			InsecureSkipVerify: r.cfg.TLS.InsecureSkipVerify,
+		}
+		r.client, r.clientErr = api.NewClient(cc)
+		if r.clientErr != nil {
+			r.clientErr = fmt.Errorf("create consul client error: %v", r.clientErr)
+		}
+	})
+	return r.client, r.clientErr
+}
 
-	// register service
-	regService := &api.AgentServiceRegistration{
-		ID:      consulServiceID,
-		Name:    cfg.Server.ServiceName,
-		Address: agentIP,
-		Port:    cfg.Server.GrpcPort,
+func (r *consulRegistry) Register(ctx context.Context, ins *ServiceInstance) error {
+	if !r.register {
+		return nil
+	}
+	host, port, err := splitHostPort(ins.Address)
+	if err != nil {
+		return err
+	}
+	client, err := r.getClient()
+	if err != nil {
+		return err
 	}
 
-	err = consulAgent.ServiceRegister(regService)
+	agent := client.Agent()
+	err = agent.ServiceRegister(&api.AgentServiceRegistration{
+		ID:      ins.ID,
+		Name:    ins.Name,
+		Address: host,
+		Port:    port,
+		Tags:    ins.Tags,
+		Meta:    ins.Meta,
+	})
 	if err != nil {
-		return fmt.Errorf("warning: register service '%s' to consul error: %s", cfg.Server.ServiceName, err.Error())
+		return fmt.Errorf("register service '%s' to consul error: %s", ins.Name, err.Error())
 	}
 
-	err = consulAgent.CheckRegister(&api.AgentCheckRegistration{
-		ID:        consulCheckID,
-		Name:      cfg.Server.ServiceName,
-		ServiceID: consulServiceID,
+	checkID := ins.ID + "-ttl"
+	err = agent.CheckRegister(&api.AgentCheckRegistration{
+		ID:        checkID,
+		Name:      ins.Name,
+		ServiceID: ins.ID,
 		AgentServiceCheck: api.AgentServiceCheck{
-			CheckID:  consulCheckID,
-			Status:   api.HealthPassing,
+			CheckID:  checkID,
+			Status:   api.HealthCritical, // passing after the first health check
 			TTL:      defaultTTL.String(),
 			Interval: defaultInterval.String(),
 			Timeout:  defaultTimeout.String(),
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("warning: register check '%s' to consul error: %s", cfg.Server.ServiceName, err.Error())
+		return fmt.Errorf("register check '%s' to consul error: %s", ins.Name, err.Error())
 	}
 
-	// async update ttl
-	go asyncUpdateTTL()
-
+	r.mu.Lock()
+	r.checkIDs = append(r.checkIDs, checkID)
+	r.mu.Unlock()
 	return nil
 }
 
-func asyncUpdateTTL() {
-	ticker := time.NewTicker(defaultTTL)
-	for {
-		<-ticker.C
-		err := consulAgent.UpdateTTL(consulCheckID, "", api.HealthPassing)
-		if err != nil {
-			log.Println("warning: update ttl of service error: ", err.Error())
+func (r *consulRegistry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	if !r.register {
+		return nil
+	}
+	checkID := ins.ID + "-ttl"
+	r.mu.Lock()
+	for i, id := range r.checkIDs {
+		if id == checkID {
+			r.checkIDs = append(r.checkIDs[:i:i], r.checkIDs[i+1:]...)
+			break
 		}
 	}
+	r.mu.Unlock()
+
+	client, err := r.getClient()
+	if err != nil {
+		return err
+	}
+	agent := client.Agent()
+	if err := agent.ServiceDeregister(ins.ID); err != nil {
+		return fmt.Errorf("deregister service error: %s", err.Error())
+	}
+	if err := agent.CheckDeregister(checkID); err != nil {
+		return fmt.Errorf("deregister check error: %s", err.Error())
+	}
+	return nil
 }
 
-func deregisterConsul() {
-	if consulAgent == nil {
+// reportHealth reports the result of the readiness probes to the ttl checks, it is called by the health checker
+// every healthCheckInterval, so that the checks expire when the process hangs.
+func (r *consulRegistry) reportHealth(status, output string) {
+	r.mu.Lock()
+	checkIDs := append([]string(nil), r.checkIDs...)
+	r.mu.Unlock()
+	if len(checkIDs) == 0 {
 		return
 	}
+	client, err := r.getClient()
+	if err != nil {
+		return
+	}
+	for _, id := range checkIDs {
+		if err := client.Agent().UpdateTTL(id, output, status); err != nil {
+			gLogger.Error("update ttl of check %s error: %s", id, err.Error())
+		}
+	}
+}
 
-	err := consulAgent.ServiceDeregister(consulServiceID)
+func (r *consulRegistry) Watch(ctx context.Context, name string) (Watcher, error) {
+	client, err := r.getClient()
 	if err != nil {
-		log.Println("warning: deregister service error: ", err.Error())
-	} else {
-		log.Println("warning: deregister service from consul server.")
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &consulWatcher{health: client.Health(), name: name, ctx: ctx, cancel: cancel}, nil
+}
+
+type consulWatcher struct {
+	health  *api.Health
+	name    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	index   uint64
+	started bool
+}
+
+// Next returns the passing instances, it blocks on the consul query until the index changes
+func (w *consulWatcher) Next() ([]*ServiceInstance, error) {
+	for {
+		opts := (&api.QueryOptions{WaitIndex: w.index, WaitTime: 5 * time.Minute}).WithContext(w.ctx)
+		entries, meta, err := w.health.Service(w.name, "", true, opts)
+		if err != nil {
+			if w.ctx.Err() != nil {
+				return nil, w.ctx.Err()
+			}
+			return nil, fmt.Errorf("query consul service %s failed: %v", w.name, err)
+		}
+		if w.started && meta.LastIndex == w.index {
+			continue
+		}
+		// the index may go backwards when consul restarts, query from the beginning
+		if meta.LastIndex < w.index {
+			w.index = 0
+		} else {
+			w.index = meta.LastIndex
+		}
+		w.started = true
+
+		list := make([]*ServiceInstance, 0, len(entries))
+		for _, e := range entries {
+			host := e.Service.Address
+			if host == "" {
+				host = e.Node.Address
+			}
+			list = append(list, &ServiceInstance{
+				ID:      e.Service.ID,
+				Name:    e.Service.Service,
+				Address: net.JoinHostPort(host, strconv.Itoa(e.Service.Port)),
+				Tags:    e.Service.Tags,
+				Meta:    e.Service.Meta,
+			})
+		}
+		return list, nil
 	}
+}
+
+func (w *consulWatcher) Stop() error {
+	w.cancel()
+	return nil
+}
 
-	err = consulAgent.CheckDeregister(consulServiceID)
+func splitHostPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	port, err := strconv.Atoi(p)
 	if err != nil {
-		log.Println("warning: deregister check error: ", err.Error())
+		return "", 0, fmt.Errorf("invalid address %s: %v", addr, err)
 	}
+	return host, port, nil
 }
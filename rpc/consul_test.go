@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestConsulRegistryRegister(t *testing.T) {
+	var (
+		services = map[string]api.AgentServiceRegistration{}
+		tokens   []string
+	)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tokens = append(tokens, req.Header.Get("X-Consul-Token"))
+		if req.URL.Path == "/v1/agent/service/register" {
+			var reg api.AgentServiceRegistration
+			json.NewDecoder(req.Body).Decode(&reg)
+			services[reg.ID] = reg
+		}
+	}))
+	defer agent.Close()
+	u, _ := url.Parse(agent.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg := &Config{
+		Server: serverConfig{ServiceName: "user", Host: "0.0.0.0", GrpcPort: 9900, HttpPort: 9901},
+		Consul: consulConfig{
+			Enabled:          true,
+			Host:             u.Hostname(),
+			Port:             port,
+			Scheme:           "http",
+			Token:            "secret",
+			AdvertiseAddress: "10.1.2.3",
+			Tags:             []string{"v2"},
+			Meta:             map[string]string{"zone": "a"},
+		},
+	}
+	registry, err := newConsulRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{cfg: cfg, Log: defaultLogger(), gs: &grpcServer{}, hs: &httpServer{}, registry: registry, health: newHealthChecker("user")}
+	s.register()
+
+	grpcReg, httpReg := services["10.1.2.3-9900"], services["10.1.2.3-9901"]
+	if grpcReg.Name != "user" || grpcReg.Address != "10.1.2.3" || grpcReg.Port != 9900 || grpcReg.Meta["zone"] != "a" || grpcReg.Meta["proto"] != "rpc" {
+		t.Errorf("grpc registration = %+v", grpcReg)
+	}
+	if httpReg.Name != "user-http" || httpReg.Port != 9901 || len(httpReg.Tags) != 2 || httpReg.Tags[0] != "v2" || httpReg.Tags[1] != "http" {
+		t.Errorf("http registration = %+v", httpReg)
+	}
+	for _, token := range tokens {
+		if token != "secret" {
+			t.Errorf("consul request token = %q, want secret", token)
+		}
+	}
+	if len(s.registered) != 2 {
+		t.Errorf("registered %d instances, want 2", len(s.registered))
+	}
+}
+
+func TestConsulRegistryLazyClient(t *testing.T) {
+	cfg := &Config{Consul: consulConfig{TLS: consulTLSConfig{CAFile: "/nonexistent/ca.pem"}}}
+	registry, err := newConsulRegistry(cfg)
+	if err != nil {
+		t.Fatalf("consul client is created while consul is disabled: %v", err)
+	}
+	if err := registry.Register(context.Background(), &ServiceInstance{ID: "a", Address: "127.0.0.1:9900"}); err != nil {
+		t.Errorf("Register with consul disabled = %v", err)
+	}
+	if _, err := registry.Watch(context.Background(), "a"); err == nil {
+		t.Errorf("Watch with an invalid consul tls config succeeded")
+	}
+
+	cfg.Consul.Enabled = true
+	if _, err := newConsulRegistry(cfg); err == nil {
+		t.Errorf("newConsulRegistry with consul enabled and an invalid tls config succeeded")
+	}
+}
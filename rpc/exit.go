@@ -1,43 +1,144 @@
 package rpc
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
+	"time"
 )
 
-func onSignal() int {
-	//de-register if meet sighup
+// SignalContext returns a context canceled when the process receives an exit signal,
+// Serve runs the server with it, programs calling Run can use it to keep the same behavior.
+// SIGHUP is not an exit signal, Run reloads the config on it.
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGQUIT)
-	x := <-ch
-	code, _ := strconv.Atoi(fmt.Sprintf("%d", x))
-	log.Println("warning: receive signal: ", x)
-	return code
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	go func() {
+		select {
+		case x := <-ch:
+			log.Println("warning: receive signal: ", x)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
+
+// OnShutdown registers a hook called during shutdown after the grpc and http servers are drained,
+// and before the clients are closed, e.g. to flush buffered data. Hooks are called in the order they are registered.
+func (s *Server) OnShutdown(f func()) {
+	s.shutdownHooks = append(s.shutdownHooks, f)
 }
 
-func onExit(code int) {
-	// close task
-	deregisterConsul()
+// Shutdown stops the server gracefully, only the first call takes effect:
+//  1. mark the server not ready and deregister from the registry, so that callers stop picking this instance
+//  2. wait shutdown_delay for the deregistration to reach the callers
+//  3. drain the in-flight grpc and http requests, connections still busy after shutdown_timeout (10s when it is 0) or when ctx is done are closed
+//  4. run the hooks registered by OnShutdown
+//  5. close the clients
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+	})
+	return s.shutdownErr
+}
+
+// defaultShutdownTimeout is the default of shutdown_timeout(ms), it is also used when shutdown_timeout is 0
+const defaultShutdownTimeout = 10000
+
+func (s *Server) shutdown(ctx context.Context) error {
+	s.health.shutdown()
+	s.deregister()
+
+	if d := s.cfg.Server.ShutdownDelay; d > 0 {
+		s.Log.Info("wait %d ms before shutdown", d)
+		select {
+		case <-time.After(time.Duration(d) * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+
+	timeout := s.cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		// a zero timeout would close the in-flight requests at once instead of draining them
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	err := s.drain(ctx)
+	if s.pprof != nil {
+		s.pprof.Close()
+	}
+
+	for _, f := range s.shutdownHooks {
+		f()
+	}
+
+	if GlobalTraceCloser != nil {
+		GlobalTraceCloser.Close()
+	}
+	stopClientWatchers()
 	closeGrpc()
 	closeDBClient()
 	closeRedisClient()
+	return err
+}
+
+// drain stops accepting new requests and waits for the in-flight ones until ctx is done,
+// it returns the error of ctx when the remaining connections are closed forcibly.
+func (s *Server) drain(ctx context.Context) error {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		if s.hs.None {
+			return
+		}
+		if err := s.hs.s.Shutdown(ctx); err != nil {
+			s.Log.Error("http server shutdown failed, error: %s", err.Error())
+			s.hs.s.Close()
+		}
+	}()
 
-	os.Exit(code)
+	go func() {
+		defer func() { done <- struct{}{} }()
+		if s.gs.None {
+			return
+		}
+		stopped := make(chan struct{})
+		go func() {
+			s.gs.s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.Log.Error("grpc server graceful stop timeout, close the remaining connections")
+			s.gs.s.Stop()
+		}
+	}()
+
+	<-done
+	<-done
+	return ctx.Err()
 }
 
 func closeGrpc() {
-	for _, conn := range grpcPools {
-		if conn != nil {
-			conn.Close()
-		}
-	}
+	grpcConns.closeAll()
 }
 func closeDBClient() {
-	// TODO
+	globalDBMap.Range(func(key, value interface{}) bool {
+		if info, ok := value.(*DBInfo); ok && info.DB != nil {
+			if db, err := info.DB.DB(); err == nil {
+				db.Close()
+			}
+		}
+		return true
+	})
 }
 
 func closeRedisClient() {
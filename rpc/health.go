@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// the health status, the same as the status of consul checks
+const (
+	HealthPassing  = api.HealthPassing  // all probes succeed
+	HealthWarning  = api.HealthWarning  // some non-critical probes fail, the server still takes traffic
+	HealthCritical = api.HealthCritical // some critical probes fail or the server is shutting down
+)
+
+const (
+	healthCheckInterval = 10 * time.Second
+	probeTimeout        = 2 * time.Second
+)
+
+// HealthResult is the body of /readyz, e.g.
+//
+//	{"status": "warning", "checks": {"mysql": "ok", "redis": "dial tcp 127.0.0.1:6379: connect: connection refused"}}
+type HealthResult struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type readinessProbe struct {
+	name     string
+	critical bool
+	probe    func(ctx context.Context) error
+}
+
+// healthChecker runs the readiness probes periodically, and reports the result to the grpc health service and the registry
+type healthChecker struct {
+	mu           sync.RWMutex
+	probes       []readinessProbe
+	shuttingDown bool
+
+	serviceName string
+	grpc        *health.Server
+	report      func(status, output string) // set when the registry keeps the health status, e.g. consul
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+func newHealthChecker(serviceName string) *healthChecker {
+	return &healthChecker{
+		serviceName: serviceName,
+		grpc:        health.NewServer(),
+		stop:        make(chan struct{}),
+	}
+}
+
+// AddReadinessProbe adds a probe checked by /readyz, the grpc health service and the consul check,
+// a failed critical probe makes the server not ready, a failed non-critical probe only makes it a warning, e.g.
+//
+//	s.AddReadinessProbe("mysql", true, rpc.DBReadinessProbe("mysql_service_name"))
+func (s *Server) AddReadinessProbe(name string, critical bool, probe func(ctx context.Context) error) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	s.health.probes = append(s.health.probes, readinessProbe{name: name, critical: critical, probe: probe})
+}
+
+// DBReadinessProbe pings the database of the [[database]] named serviceName
+func DBReadinessProbe(serviceName string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		info := loadDB(serviceName)
+		if info == nil || info.DB == nil {
+			return fmt.Errorf("database %s not found", serviceName)
+		}
+		db, err := info.DB.DB()
+		if err != nil {
+			return err
+		}
+		return db.PingContext(ctx)
+	}
+}
+
+// RedisReadinessProbe sends PING to the redis of the [[redis]] named serviceName
+func RedisReadinessProbe(serviceName string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := DoRedis(ctx, serviceName, "PING")
+		return err
+	}
+}
+
+// check runs all probes concurrently
+func (h *healthChecker) check(ctx context.Context) HealthResult {
+	h.mu.RLock()
+	probes, shuttingDown := h.probes, h.shuttingDown
+	h.mu.RUnlock()
+
+	if shuttingDown {
+		return HealthResult{Status: HealthCritical, Checks: map[string]string{"server": "shutting down"}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	errs := make([]error, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p readinessProbe) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("probe panic: %v", r)
+				}
+			}()
+			errs[i] = p.probe(ctx)
+		}(i, p)
+	}
+	wg.Wait()
+
+	result := HealthResult{Status: HealthPassing, Checks: make(map[string]string, len(probes))}
+	for i, p := range probes {
+		if errs[i] == nil {
+			result.Checks[p.name] = "ok"
+			continue
+		}
+		result.Checks[p.name] = errs[i].Error()
+		if p.critical {
+			result.Status = HealthCritical
+		} else if result.Status == HealthPassing {
+			result.Status = HealthWarning
+		}
+	}
+	return result
+}
+
+// update checks the probes and reports the status to the grpc health service and the registry
+func (h *healthChecker) update() {
+	result := h.check(context.Background())
+
+	st := healthpb.HealthCheckResponse_SERVING
+	if result.Status == HealthCritical {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	h.grpc.SetServingStatus("", st)
+	h.grpc.SetServingStatus(h.serviceName, st)
+
+	if h.report != nil {
+		h.report(result.Status, result.output())
+	}
+}
+
+func (h *healthChecker) run() {
+	h.update()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.update()
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// shutdown marks the server not ready, so that /readyz and the grpc health service stop sending traffic to it
+func (h *healthChecker) shutdown() {
+	h.mu.Lock()
+	h.shuttingDown = true
+	h.mu.Unlock()
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.grpc.Shutdown()
+}
+
+// output is the consul check output, the failed probes
+func (r HealthResult) output() string {
+	var failed []string
+	for name, msg := range r.Checks {
+		if msg != "ok" {
+			failed = append(failed, name+": "+msg)
+		}
+	}
+	sort.Strings(failed)
+	return strings.Join(failed, "\n")
+}
+
+// handleHealth registers /healthz for liveness, it succeeds as long as the process serves http,
+// and /readyz for readiness, it fails with 503 when a critical probe fails.
+func handleHealth(mux *HttpMux, h *healthChecker) {
+	mux.HandleFunc("GET", "/healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeHealthResult(w, http.StatusOK, HealthResult{Status: HealthPassing})
+	})
+	mux.HandleFunc("GET", "/readyz", func(w http.ResponseWriter, req *http.Request) {
+		result := h.check(req.Context())
+		code := http.StatusOK
+		if result.Status == HealthCritical {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealthResult(w, code, result)
+	})
+}
+
+func writeHealthResult(w http.ResponseWriter, code int, result HealthResult) {
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecker(t *testing.T) {
+	fail := func(ctx context.Context) error { return errors.New("down") }
+	ok := func(ctx context.Context) error { return nil }
+
+	tests := []struct {
+		name   string
+		probes []readinessProbe
+		status string
+		code   int
+	}{
+		{"no probes", nil, HealthPassing, 200},
+		{"all ok", []readinessProbe{{"db", true, ok}, {"cache", false, ok}}, HealthPassing, 200},
+		{"non-critical failed", []readinessProbe{{"db", true, ok}, {"cache", false, fail}}, HealthWarning, 200},
+		{"critical failed", []readinessProbe{{"db", true, fail}, {"cache", false, fail}}, HealthCritical, 503},
+	}
+	for _, tt := range tests {
+		h := newHealthChecker("test")
+		h.probes = tt.probes
+		mux := NewHttpMux()
+		handleHealth(mux, h)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var result HealthResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.code || result.Status != tt.status || len(result.Checks) != len(tt.probes) {
+			t.Errorf("%s: /readyz = %d %s, want %d %s", tt.name, rec.Code, rec.Body.String(), tt.code, tt.status)
+		}
+
+		h.update()
+		resp, err := h.grpc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "test"})
+		want := healthpb.HealthCheckResponse_SERVING
+		if tt.status == HealthCritical {
+			want = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if err != nil || resp.Status != want {
+			t.Errorf("%s: grpc health = %v %v, want %v", tt.name, resp, err, want)
+		}
+
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		if rec.Code != 200 {
+			t.Errorf("%s: /healthz = %d, want 200", tt.name, rec.Code)
+		}
+	}
+}
+
+func TestHealthCheckerShutdown(t *testing.T) {
+	h := newHealthChecker("test")
+	h.shutdown()
+	if result := h.check(context.Background()); result.Status != HealthCritical {
+		t.Errorf("status after shutdown = %s, want critical", result.Status)
+	}
+	resp, err := h.grpc.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("grpc health after shutdown = %v %v", resp, err)
+	}
+}
@@ -0,0 +1,361 @@
+package rpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// BindHttpRequest fills msg from req following the rules of google.api.http:
+//   - the body is decoded into msg when body is "*", or into the top level field named by body,
+//     an empty body means the request has no body
+//   - the variables of the path template are bound to the fields they name
+//   - the query parameters are bound to the remaining fields unless body is "*",
+//     e.g. ?page.size=10&tags=a&tags=b, unknown parameters are ignored
+//
+// errors are returned as status errors with codes.InvalidArgument.
+func BindHttpRequest(req *http.Request, msg proto.Message, body string, opts protojson.UnmarshalOptions) error {
+	m := msg.ProtoReflect()
+
+	if body != "" && req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "read request body failed: %v", err)
+		}
+		if len(data) != 0 {
+			target := m
+			if body != "*" {
+				fd := findField(m.Descriptor(), body)
+				if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+					return status.Errorf(codes.Internal, "invalid body field %q of %s", body, m.Descriptor().FullName())
+				}
+				target = m.Mutable(fd).Message()
+			}
+			if err := opts.Unmarshal(data, target.Interface()); err != nil {
+				return status.Errorf(codes.InvalidArgument, "decode request body failed: %v", err)
+			}
+		}
+	}
+
+	pathParams := HttpPathParams(req)
+	for name, value := range pathParams {
+		if err := setFieldByPath(m, name, []string{value}); err != nil {
+			return status.Errorf(codes.InvalidArgument, "bind path parameter %q failed: %v", name, err)
+		}
+	}
+
+	if body == "*" {
+		return nil
+	}
+	for name, values := range req.URL.Query() {
+		if _, ok := pathParams[name]; ok {
+			continue
+		}
+		if body != "" && (name == body || strings.HasPrefix(name, body+".")) {
+			continue
+		}
+		if err := setFieldByPath(m, name, values); err != nil {
+			if err == errUnknownField {
+				continue
+			}
+			return status.Errorf(codes.InvalidArgument, "bind query parameter %q failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+var errUnknownField = fmt.Errorf("unknown field")
+
+// findField looks up a field by its proto name or json name
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// setFieldByPath sets the field named by a dotted path like "page.size", intermediate messages are created as needed
+func setFieldByPath(m protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := findField(m.Descriptor(), name)
+		if fd == nil {
+			return errUnknownField
+		}
+		if i == len(names)-1 {
+			return setField(m, fd, values)
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("%s is not a message field", fd.FullName())
+		}
+		m = m.Mutable(fd).Message()
+	}
+	return nil
+}
+
+func setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, values []string) error {
+	if fd.IsMap() {
+		return fmt.Errorf("map field %s is not supported", fd.FullName())
+	}
+	if fd.IsList() {
+		list := m.Mutable(fd).List()
+		for _, s := range values {
+			v, err := parseFieldValue(fd, list.NewElement, s)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("too many values for singular field %s", fd.FullName())
+	}
+	v, err := parseFieldValue(fd, func() protoreflect.Value { return m.NewField(fd) }, values[0])
+	if err != nil {
+		return err
+	}
+	m.Set(fd, v)
+	return nil
+}
+
+func parseFieldValue(fd protoreflect.FieldDescriptor, newValue func() protoreflect.Value, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid value %q for enum %s", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// well-known types like Timestamp, Duration, FieldMask and wrappers use their json string form
+		v := newValue()
+		if err := protojson.Unmarshal([]byte(strconv.Quote(s)), v.Message().Interface()); err != nil {
+			if err := protojson.Unmarshal([]byte(s), v.Message().Interface()); err != nil {
+				return protoreflect.Value{}, fmt.Errorf("invalid value %q for %s", s, fd.Message().FullName())
+			}
+		}
+		return v, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+// encodeHttpRequest is the reverse of BindHttpRequest used by the http clients, it expands the path template of route
+// with the fields of msg, encodes the body field and puts the remaining populated fields in the query
+func encodeHttpRequest(route HttpRoute, msg proto.Message, opts protojson.MarshalOptions) (uri string, body []byte, err error) {
+	p, err := parsePathPattern(route.Pattern)
+	if err != nil {
+		return "", nil, status.Errorf(codes.Internal, "invalid http pattern %q: %v", route.Pattern, err)
+	}
+	m := msg.ProtoReflect()
+
+	var b strings.Builder
+	skip := make(map[string]bool)
+	for i := 0; i < len(p.segments); {
+		b.WriteByte('/')
+		if v := p.variableAt(i); v != nil {
+			value, err := formatFieldByPath(m, v.name)
+			if err != nil {
+				return "", nil, status.Errorf(codes.InvalidArgument, "bind path parameter %q failed: %v", v.name, err)
+			}
+			if value == "" {
+				return "", nil, status.Errorf(codes.InvalidArgument, "path parameter %q is empty", v.name)
+			}
+			if v.end-v.start == 1 && p.segments[v.start].kind == segWildcard {
+				b.WriteString(url.PathEscape(value))
+			} else {
+				parts := strings.Split(value, "/")
+				for j, part := range parts {
+					parts[j] = url.PathEscape(part)
+				}
+				b.WriteString(strings.Join(parts, "/"))
+			}
+			skip[v.name] = true
+			i = v.end
+			continue
+		}
+		b.WriteString(p.segments[i].literal)
+		i++
+	}
+	if len(p.segments) == 0 {
+		b.WriteByte('/')
+	}
+	if p.verb != "" {
+		b.WriteString(":" + p.verb)
+	}
+
+	switch route.Body {
+	case "":
+	case "*":
+		if body, err = opts.Marshal(msg); err != nil {
+			return "", nil, status.Errorf(codes.Internal, "encode request failed: %v", err)
+		}
+		return b.String(), body, nil
+	default:
+		fd := findField(m.Descriptor(), route.Body)
+		if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return "", nil, status.Errorf(codes.Internal, "invalid body field %q of %s", route.Body, m.Descriptor().FullName())
+		}
+		if body, err = opts.Marshal(m.Get(fd).Message().Interface()); err != nil {
+			return "", nil, status.Errorf(codes.Internal, "encode request failed: %v", err)
+		}
+		skip[string(fd.Name())] = true
+	}
+
+	query := url.Values{}
+	if err := appendQuery(query, "", m, skip); err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "encode query parameters failed: %v", err)
+	}
+	if len(query) != 0 {
+		b.WriteString("?" + query.Encode())
+	}
+	return b.String(), body, nil
+}
+
+// variableAt returns the variable starting at segment i
+func (p *pathPattern) variableAt(i int) *pathVariable {
+	for j := range p.variables {
+		if p.variables[j].start == i {
+			return &p.variables[j]
+		}
+	}
+	return nil
+}
+
+// formatFieldByPath formats the field named by a dotted path, unset fields have their default values
+func formatFieldByPath(m protoreflect.Message, path string) (string, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := findField(m.Descriptor(), name)
+		if fd == nil {
+			return "", errUnknownField
+		}
+		if i == len(names)-1 {
+			if fd.IsList() || fd.IsMap() {
+				return "", fmt.Errorf("%s is not a singular field", fd.FullName())
+			}
+			return formatFieldValue(fd, m.Get(fd))
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return "", fmt.Errorf("%s is not a message field", fd.FullName())
+		}
+		m = m.Get(fd).Message()
+	}
+	return "", nil
+}
+
+// appendQuery adds the populated fields of m except the ones in skip, the repeated fields are added once per element
+func appendQuery(query url.Values, prefix string, m protoreflect.Message, skip map[string]bool) (err error) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := prefix + string(fd.Name())
+		switch {
+		case skip[name]:
+		case fd.IsMap():
+			err = fmt.Errorf("map field %s is not supported", fd.FullName())
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len() && err == nil; i++ {
+				var s string
+				if s, err = formatFieldValue(fd, list.Get(i)); err == nil {
+					query.Add(name, s)
+				}
+			}
+		case fd.Message() != nil && !isWellKnownType(fd.Message()):
+			err = appendQuery(query, name+".", v.Message(), skip)
+		default:
+			var s string
+			if s, err = formatFieldValue(fd, v); err == nil {
+				query.Add(name, s)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+func isWellKnownType(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile().Package() == "google.protobuf"
+}
+
+// formatFieldValue formats a value in the form parsed by parseFieldValue
+func formatFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (string, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(v.Bool()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case protoreflect.StringKind:
+		return v.String(), nil
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+		return strconv.FormatInt(int64(v.Enum()), 10), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// well-known types use their json string form, the other messages their json object
+		b, err := protojson.Marshal(v.Message().Interface())
+		if err != nil {
+			return "", err
+		}
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			return s, nil
+		}
+		return string(b), nil
+	}
+	return "", fmt.Errorf("unsupported field type %s", fd.Kind())
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// AddOpenAPI adds the OpenAPI v3 document of a service, it is called by the generated RegisterXxxHttpServer.
+// The documents of all services are merged and served with the swagger ui when docs is enabled in the config.
+func (m *HttpMux) AddOpenAPI(doc string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = append(m.docs, doc)
+}
+
+// OpenAPI returns one OpenAPI document merging the paths, tags and schemas of all added documents
+func (m *HttpMux) OpenAPI(title string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var (
+		paths   = map[string]map[string]json.RawMessage{}
+		schemas = map[string]json.RawMessage{}
+		tags    []json.RawMessage
+	)
+	for _, doc := range m.docs {
+		var d struct {
+			Tags       []json.RawMessage                     `json:"tags"`
+			Paths      map[string]map[string]json.RawMessage `json:"paths"`
+			Components struct {
+				Schemas map[string]json.RawMessage `json:"schemas"`
+			} `json:"components"`
+		}
+		if err := json.Unmarshal([]byte(doc), &d); err != nil {
+			return nil, fmt.Errorf("invalid openapi document: %v", err)
+		}
+		tags = append(tags, d.Tags...)
+		for path, ops := range d.Paths {
+			if paths[path] == nil {
+				paths[path] = map[string]json.RawMessage{}
+			}
+			for method, op := range ops {
+				paths[path][method] = op
+			}
+		}
+		for name, schema := range d.Components.Schemas {
+			schemas[name] = schema
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]string{"title": title, "version": "version not set"},
+		"tags":       tags,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	})
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.UIUrl}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.UIUrl}}/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: {{.SpecUrl}}, dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`))
+
+// handleDocs registers the swagger ui at {path}/ and the merged OpenAPI document at {path}/openapi.json,
+// the swagger-ui-dist assets embedded by github.com/swaggo/files are served under {path}/ unless ui_url is set
+func handleDocs(mux *HttpMux, title string, cfg docsConfig) {
+	path := strings.TrimRight(cfg.Path, "/")
+	specPath := path + "/openapi.json"
+
+	uiUrl := strings.TrimSuffix(cfg.UIUrl, "/")
+	if uiUrl == "" {
+		uiUrl = path
+		mux.Handle("GET", path+"/{file}", http.StripPrefix(path, http.FileServer(http.FS(swaggerFiles.FS))))
+	}
+
+	mux.HandleFunc("GET", specPath, func(w http.ResponseWriter, req *http.Request) {
+		b, err := mux.OpenAPI(title)
+		if err != nil {
+			WriteHttpError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+
+	ui := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := swaggerUITemplate.Execute(w, map[string]string{
+			"Title":   title,
+			"UIUrl":   uiUrl,
+			"SpecUrl": specPath,
+		})
+		if err != nil {
+			gLogger.Error("render swagger ui failed, error: %s", err.Error())
+		}
+	}
+	mux.HandleFunc("GET", path, ui)
+	mux.HandleFunc("GET", path+"/index.html", ui)
+}
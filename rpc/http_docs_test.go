@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleDocs(t *testing.T) {
+	mux := NewHttpMux()
+	mux.AddOpenAPI(`{"tags":[{"name":"a.A"}],"paths":{"/v1/a":{"get":{"operationId":"A_Get"}}},"components":{"schemas":{"a.Req":{"type":"object"}}}}`)
+	mux.AddOpenAPI(`{"tags":[{"name":"b.B"}],"paths":{"/v1/a":{"post":{"operationId":"B_Post"}}},"components":{"schemas":{"b.Req":{"type":"object"}}}}`)
+	handleDocs(mux, "demo", docsConfig{Path: "/docs/", UIUrl: "https://cdn.example.com/swagger-ui/"})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/openapi.json", nil))
+	if rec.Code != 200 {
+		t.Fatalf("openapi.json: code = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var doc struct {
+		Info       struct{ Title string }
+		Tags       []struct{ Name string }
+		Paths      map[string]map[string]struct{ OperationID string }
+		Components struct{ Schemas map[string]interface{} }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Title != "demo" || len(doc.Tags) != 2 || len(doc.Paths["/v1/a"]) != 2 || len(doc.Components.Schemas) != 2 {
+		t.Errorf("merged document = %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	body := rec.Body.String()
+	if rec.Code != 200 || !strings.Contains(body, `https://cdn.example.com/swagger-ui/swagger-ui-bundle.js`) || !strings.Contains(body, `"/docs/openapi.json"`) {
+		t.Errorf("swagger ui: code = %d, body: %s", rec.Code, body)
+	}
+}
+
+func TestHandleDocsEmbedded(t *testing.T) {
+	mux := NewHttpMux()
+	handleDocs(mux, "demo", docsConfig{Path: "/docs"})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if !strings.Contains(rec.Body.String(), `src="/docs/swagger-ui-bundle.js"`) {
+		t.Errorf("swagger ui doesn't load the embedded assets: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/swagger-ui-bundle.js", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "SwaggerUIBundle") {
+		t.Errorf("embedded asset: code = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/swagger-ui.css", nil))
+	if rec.Code != 200 || rec.Body.Len() == 0 {
+		t.Errorf("embedded asset: code = %d", rec.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HttpStatusFromCode converts a gRPC error code into the corresponding HTTP response status.
+// See: https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func HttpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		// Note, this deliberately doesn't translate to the similarly named '412 Precondition Failed' HTTP response status.
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+	return http.StatusInternalServerError
+}
+
+// HttpStatusToCode converts a HTTP response status into the closest gRPC error code,
+// it is used for error responses without a google.rpc.Status body.
+func HttpStatusToCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Unknown
+}
+
+var errorMarshalOptions = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// WriteHttpError writes err to w as a json body of google.rpc.Status, e.g.
+//
+//	{"code": 5, "message": "user not found", "details": []}
+//
+// the http status code is derived from the grpc code of err, errors not created by package status are treated as codes.Unknown.
+func WriteHttpError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeHttpStatus(w, HttpStatusFromCode(st.Code()), st)
+}
+
+func writeHttpStatus(w http.ResponseWriter, httpStatus int, st *status.Status) {
+	b, err := errorMarshalOptions.Marshal(st.Proto())
+	if err != nil {
+		// details can't be marshaled when their types are not linked into the binary, drop them
+		gLogger.Error("marshal http error details failed, error: %s", err.Error())
+		b, _ = errorMarshalOptions.Marshal(status.New(st.Code(), st.Message()).Proto())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(b)
+}
+
+// decodeHttpError converts an error response into a status error, the body written by WriteHttpError keeps its code,
+// message and details, other bodies are mapped by HttpStatusToCode.
+func decodeHttpError(httpStatus int, body []byte) error {
+	st := &spb.Status{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, st); err == nil && st.Code != 0 {
+		return status.ErrorProto(st)
+	}
+	return status.Error(HttpStatusToCode(httpStatus), fmt.Sprintf("http status %d: %s", httpStatus, body))
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteHttpError(t *testing.T) {
+	st, err := status.New(codes.InvalidArgument, "bad value").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "value", Description: "empty"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    codes.Code
+		wantMessage string
+		wantDetails int
+	}{
+		{"not found", status.Error(codes.NotFound, "no such user"), http.StatusNotFound, codes.NotFound, "no such user", 0},
+		{"unavailable", status.Error(codes.Unavailable, "try later"), http.StatusServiceUnavailable, codes.Unavailable, "try later", 0},
+		{"with details", st.Err(), http.StatusBadRequest, codes.InvalidArgument, "bad value", 1},
+		{"plain error", errors.New("boom"), http.StatusInternalServerError, codes.Unknown, "boom", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WriteHttpError(rec, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type = %q, want application/json", ct)
+			}
+			var body struct {
+				Code    codes.Code        `json:"code"`
+				Message string            `json:"message"`
+				Details []json.RawMessage `json:"details"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid json body %q: %v", rec.Body.String(), err)
+			}
+			if body.Code != tt.wantCode || body.Message != tt.wantMessage || len(body.Details) != tt.wantDetails {
+				t.Errorf("body = %s, want code %d message %q with %d details", rec.Body.String(), tt.wantCode, tt.wantMessage, tt.wantDetails)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HttpMux is a http request router which matches requests against path templates of google.api.http, e.g.
+//
+//	/v1/users/{id}
+//	/v1/{name=shelves/*/books/*}:publish
+//	/static/{path=**}
+//
+// the variables matched in the path are available to handlers by HttpPathParams.
+// The routes of all services share one HttpMux owned by Server, see Server.HttpMux.
+type HttpMux struct {
+	mu     sync.RWMutex
+	routes []*httpRoute
+	docs   []string // OpenAPI documents of the registered services
+}
+
+// MiddlewareFunc wraps a http handler, e.g. for logging or authentication
+type MiddlewareFunc func(http.Handler) http.Handler
+
+type httpRoute struct {
+	method  string // empty method matches any http method
+	pattern *pathPattern
+	handler http.Handler
+}
+
+type pathParamsKey struct{}
+
+// NewHttpMux create an empty router
+func NewHttpMux() *HttpMux {
+	return &HttpMux{}
+}
+
+// Handle registers the handler for the given http method and path template, an empty method matches any method.
+// It panics if the template is malformed or conflicts with a registered route,
+// two templates conflict when they only differ in variable names, e.g. GET /v1/users/{id} and GET /v1/users/{name}.
+func (m *HttpMux) Handle(method, pattern string, h http.Handler) {
+	p, err := parsePathPattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("rpc: invalid http pattern %q: %v", pattern, err))
+	}
+	r := &httpRoute{
+		method:  strings.ToUpper(method),
+		pattern: p,
+		handler: h,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, exist := range m.routes {
+		if exist.conflictsWith(r) {
+			panic(fmt.Sprintf("rpc: http route %s conflicts with registered route %s", r, exist))
+		}
+	}
+	m.routes = append(m.routes, r)
+}
+
+// HandleFunc registers the handler function for the given http method and path template.
+func (m *HttpMux) HandleFunc(method, pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.Handle(method, pattern, http.HandlerFunc(h))
+}
+
+func (m *HttpMux) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	components, verb := splitRequestPath(req.URL.EscapedPath())
+
+	// the handler is called without the lock, streaming handlers may run for long and routes may be registered meanwhile
+	best, params, allowed := m.match(req.Method, components, verb)
+	if best == nil {
+		if len(allowed) != 0 {
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeHttpStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, fmt.Sprintf("method %s not allowed for %s", req.Method, req.URL.Path)))
+			return
+		}
+		WriteHttpError(w, status.Errorf(codes.NotFound, "no handler for %s %s", req.Method, req.URL.Path))
+		return
+	}
+
+	if len(params) != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), pathParamsKey{}, params))
+	}
+	best.handler.ServeHTTP(w, req)
+}
+
+// match returns the most specific route of the request, or the methods allowed for the path when no route matches the method
+func (m *HttpMux) match(method string, components []string, verb string) (best *httpRoute, bestParams map[string]string, allowed []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.routes {
+		params, ok := r.pattern.match(components, verb)
+		if !ok {
+			continue
+		}
+		if r.method != "" && r.method != method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+		if best == nil || r.pattern.moreSpecificThan(best.pattern) {
+			best, bestParams = r, params
+		}
+	}
+	return best, bestParams, allowed
+}
+
+func (r *httpRoute) conflictsWith(o *httpRoute) bool {
+	if r.method != "" && o.method != "" && r.method != o.method {
+		return false
+	}
+	return r.pattern.shape() == o.pattern.shape()
+}
+
+func (r *httpRoute) String() string {
+	method := r.method
+	if method == "" {
+		method = "*"
+	}
+	return method + " " + r.pattern.tmpl
+}
+
+// HttpPathParams returns the variables matched by the path template of the route serving req
+func HttpPathParams(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(pathParamsKey{}).(map[string]string)
+	return params
+}
+
+const (
+	segLiteral = iota
+	segWildcard
+	segDeepWildcard
+)
+
+type pathSegment struct {
+	kind    int
+	literal string
+}
+
+type pathVariable struct {
+	name       string
+	start, end int // segments [start, end) are bound to the variable
+}
+
+type pathPattern struct {
+	tmpl      string
+	segments  []pathSegment
+	variables []pathVariable
+	verb      string
+	deep      bool // the last segment is **
+	literals  int
+}
+
+// parsePathPattern parses the path template syntax of google.api.http:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	Verb     = ":" LITERAL ;
+func parsePathPattern(tmpl string) (*pathPattern, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("template must start with /")
+	}
+	p := &pathPattern{tmpl: tmpl}
+	rest := tmpl[1:]
+
+	// the verb follows the last segment, a ':' inside a variable doesn't count
+	if i := strings.LastIndex(rest, ":"); i >= 0 && i > strings.LastIndex(rest, "}") && i > strings.LastIndex(rest, "/") {
+		p.verb = rest[i+1:]
+		rest = rest[:i]
+		if p.verb == "" {
+			return nil, fmt.Errorf("empty verb")
+		}
+	}
+	if rest == "" {
+		return p, nil
+	}
+
+	for len(rest) > 0 {
+		var seg string
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable")
+			}
+			if err := p.addVariable(rest[1:end]); err != nil {
+				return nil, err
+			}
+			rest = rest[end+1:]
+		} else {
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				seg, rest = rest[:i], rest[i:]
+			} else {
+				seg, rest = rest, ""
+			}
+			if err := p.addSegment(seg); err != nil {
+				return nil, err
+			}
+		}
+		if rest == "" {
+			break
+		}
+		if rest[0] != '/' || len(rest) == 1 {
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+		rest = rest[1:]
+	}
+	return p, nil
+}
+
+func (p *pathPattern) addSegment(seg string) error {
+	if p.deep {
+		return fmt.Errorf("** must be the last segment")
+	}
+	switch {
+	case seg == "":
+		return fmt.Errorf("empty segment")
+	case seg == "*":
+		p.segments = append(p.segments, pathSegment{kind: segWildcard})
+	case seg == "**":
+		p.segments = append(p.segments, pathSegment{kind: segDeepWildcard})
+		p.deep = true
+	case strings.ContainsAny(seg, "{}*"):
+		return fmt.Errorf("invalid segment %q", seg)
+	default:
+		p.segments = append(p.segments, pathSegment{kind: segLiteral, literal: seg})
+		p.literals++
+	}
+	return nil
+}
+
+func (p *pathPattern) addVariable(v string) error {
+	name, sub := v, "*"
+	if i := strings.IndexByte(v, '='); i >= 0 {
+		name, sub = v[:i], v[i+1:]
+	}
+	if name == "" {
+		return fmt.Errorf("empty variable name")
+	}
+	for _, exist := range p.variables {
+		if exist.name == name {
+			return fmt.Errorf("duplicate variable %q", name)
+		}
+	}
+	start := len(p.segments)
+	for _, seg := range strings.Split(sub, "/") {
+		if err := p.addSegment(seg); err != nil {
+			return err
+		}
+	}
+	p.variables = append(p.variables, pathVariable{name: name, start: start, end: len(p.segments)})
+	return nil
+}
+
+// match checks the escaped path components against the pattern and returns the unescaped variables
+func (p *pathPattern) match(components []string, verb string) (map[string]string, bool) {
+	if verb != p.verb {
+		// the request path may contain ':' without the route having a verb
+		if p.verb != "" || len(components) == 0 {
+			return nil, false
+		}
+		components = append(components[:len(components)-1:len(components)-1], components[len(components)-1]+":"+verb)
+	}
+	if p.deep {
+		if len(components) < len(p.segments)-1 {
+			return nil, false
+		}
+	} else if len(components) != len(p.segments) {
+		return nil, false
+	}
+
+	for i, seg := range p.segments {
+		switch seg.kind {
+		case segLiteral:
+			if components[i] != seg.literal {
+				return nil, false
+			}
+		case segWildcard:
+			if components[i] == "" {
+				return nil, false
+			}
+		}
+	}
+
+	if len(p.variables) == 0 {
+		return nil, true
+	}
+	params := make(map[string]string, len(p.variables))
+	for _, v := range p.variables {
+		end := v.end
+		if end == len(p.segments) && p.deep {
+			end = len(components)
+		}
+		values := make([]string, 0, end-v.start)
+		for _, c := range components[v.start:end] {
+			s, err := url.PathUnescape(c)
+			if err != nil {
+				return nil, false
+			}
+			values = append(values, s)
+		}
+		params[v.name] = strings.Join(values, "/")
+	}
+	return params, true
+}
+
+// shape is the template with variable names removed, templates of the same shape match the same paths
+func (p *pathPattern) shape() string {
+	parts := make([]string, len(p.segments))
+	for i, seg := range p.segments {
+		switch seg.kind {
+		case segLiteral:
+			parts[i] = seg.literal
+		case segWildcard:
+			parts[i] = "*"
+		case segDeepWildcard:
+			parts[i] = "**"
+		}
+	}
+	return "/" + strings.Join(parts, "/") + ":" + p.verb
+}
+
+// moreSpecificThan prefers literal segments over wildcards, e.g. /v1/users/me over /v1/users/{id}
+func (p *pathPattern) moreSpecificThan(o *pathPattern) bool {
+	if p.literals != o.literals {
+		return p.literals > o.literals
+	}
+	if p.deep != o.deep {
+		return !p.deep
+	}
+	return p.verb != "" && o.verb == ""
+}
+
+func splitRequestPath(path string) ([]string, string) {
+	path = strings.TrimPrefix(path, "/")
+	var verb string
+	if i := strings.LastIndex(path, ":"); i >= 0 && i > strings.LastIndex(path, "/") {
+		path, verb = path[:i], path[i+1:]
+	}
+	if path == "" {
+		return nil, verb
+	}
+	return strings.Split(path, "/"), verb
+}
@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestHttpMux(t *testing.T) {
+	mux := NewHttpMux()
+	route := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			params := HttpPathParams(req)
+			var kv []string
+			for _, k := range []string{"id", "name", "path"} {
+				if v, ok := params[k]; ok {
+					kv = append(kv, k+"="+v)
+				}
+			}
+			w.Write([]byte(name + " " + strings.Join(kv, ",")))
+		}
+	}
+	mux.Handle("GET", "/v1/users/{id}", route("get"))
+	mux.Handle("GET", "/v1/users/me", route("me"))
+	mux.Handle("DELETE", "/v1/users/{id}", route("delete"))
+	mux.Handle("POST", "/v1/{name=shelves/*/books/*}:publish", route("publish"))
+	mux.Handle("GET", "/static/{path=**}", route("static"))
+	mux.Handle("", "/EchoService/Echo", route("echo"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/v1/users/42", 200, "get id=42"},
+		{"GET", "/v1/users/me", 200, "me "},
+		{"DELETE", "/v1/users/a%2Fb", 200, "delete id=a/b"},
+		{"POST", "/v1/shelves/1/books/2:publish", 200, "publish name=shelves/1/books/2"},
+		{"GET", "/static/js/app.js", 200, "static path=js/app.js"},
+		{"PUT", "/EchoService/Echo", 200, "echo "},
+		{"PUT", "/v1/users/42", 405, ""},
+		{"GET", "/v1/users/42/books", 404, ""},
+		{"POST", "/v1/shelves/1/books/2", 404, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: code = %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+			continue
+		}
+		if tt.code == 200 && rec.Body.String() != tt.body {
+			t.Errorf("%s %s: body = %q, want %q", tt.method, tt.path, rec.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestHttpMuxConflict(t *testing.T) {
+	h := http.NotFoundHandler()
+	tests := []struct {
+		registered [2]string
+		method     string
+		pattern    string
+		conflict   bool
+	}{
+		{[2]string{"GET", "/v1/users/{id}"}, "GET", "/v1/users/{name}", true},
+		{[2]string{"GET", "/v1/users/{id}"}, "", "/v1/users/{id}", true},
+		{[2]string{"GET", "/v1/users/{id}"}, "DELETE", "/v1/users/{id}", false},
+		{[2]string{"GET", "/v1/users/{id}"}, "GET", "/v1/users/me", false},
+		{[2]string{"POST", "/v1/{name=books/*}:publish"}, "POST", "/v1/books/{id}:publish", true},
+		{[2]string{"POST", "/v1/{name=books/*}:publish"}, "POST", "/v1/books/{id}", false},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if r := recover(); (r != nil) != tt.conflict {
+					t.Errorf("register %s %s after %v: conflict = %v, want %v", tt.method, tt.pattern, tt.registered, r != nil, tt.conflict)
+				}
+			}()
+			mux := NewHttpMux()
+			mux.Handle(tt.registered[0], tt.registered[1], h)
+			mux.Handle(tt.method, tt.pattern, h)
+		}()
+	}
+}
+
+func TestHttpMuxHandleWhileStreaming(t *testing.T) {
+	mux := NewHttpMux()
+	started, release := make(chan struct{}), make(chan struct{})
+	mux.HandleFunc("GET", "/stream", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+	mux.HandleFunc("GET", "/ping", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(release)
+
+	go http.Get(ts.URL + "/stream")
+	<-started
+
+	registered := make(chan struct{})
+	go func() {
+		mux.HandleFunc("GET", "/new", func(w http.ResponseWriter, req *http.Request) {})
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("Handle is blocked by an open stream")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/ping")
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request is blocked by an open stream")
+	}
+}
+
+func TestParsePathPatternInvalid(t *testing.T) {
+	for _, tmpl := range []string{"v1/users", "/v1/{id", "/v1//users", "/v1/**/users", "/v1/{id}/{id}", "/v1/users:", "/v1/"} {
+		if _, err := parsePathPattern(tmpl); err == nil {
+			t.Errorf("parsePathPattern(%q) should fail", tmpl)
+		}
+	}
+}
+
+func TestBindHttpRequest(t *testing.T) {
+	mux := NewHttpMux()
+	var got *descriptorpb.FieldDescriptorProto
+	bind := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			got = new(descriptorpb.FieldDescriptorProto)
+			if err := BindHttpRequest(req, got, body, protojson.UnmarshalOptions{}); err != nil {
+				WriteHttpError(w, err)
+			}
+		}
+	}
+	mux.Handle("GET", "/fields/{name}", bind(""))
+	mux.Handle("PATCH", "/fields/{name}", bind("options"))
+	mux.Handle("POST", "/fields", bind("*"))
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		want               *descriptorpb.FieldDescriptorProto
+	}{
+		{
+			"GET", "/fields/id?number=3&label=LABEL_REPEATED&options.packed=true&jsonName=ID&unknown=1", "", 200,
+			&descriptorpb.FieldDescriptorProto{
+				Name:     proto.String("id"),
+				Number:   proto.Int32(3),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				JsonName: proto.String("ID"),
+				Options:  &descriptorpb.FieldOptions{Packed: proto.Bool(true)},
+			},
+		},
+		{
+			"PATCH", "/fields/id?number=4", `{"deprecated": true}`, 200,
+			&descriptorpb.FieldDescriptorProto{
+				Name:    proto.String("id"),
+				Number:  proto.Int32(4),
+				Options: &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)},
+			},
+		},
+		{
+			"POST", "/fields?number=5", `{"name": "id", "number": 1}`, 200,
+			&descriptorpb.FieldDescriptorProto{Name: proto.String("id"), Number: proto.Int32(1)},
+		},
+		{"GET", "/fields/id?number=abc", "", 400, nil},
+		{"POST", "/fields", `{"name": `, 400, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: code = %d, want %d, body: %s", tt.method, tt.path, rec.Code, tt.code, rec.Body.String())
+			continue
+		}
+		if tt.want != nil && !proto.Equal(got, tt.want) {
+			t.Errorf("%s %s: bound %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeSSE    = "text/event-stream"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// HttpServerStream adapts a http response to grpc.ServerStream, so that server streaming methods can be served over http.
+// The messages are written as they are sent, the format is selected by the Accept header of the request.
+// For text/event-stream the server-sent events are written, each message is a "message" event and an error ends the stream
+// with an "error" event:
+//
+//	data: {"value":"a"}
+//
+//	event: error
+//	data: {"code":13, "message":"...", "details":[]}
+//
+// Otherwise newline delimited json is written, one object per line wrapping a message or an error:
+//
+//	{"result": {"value":"a"}}
+//	{"error": {"code":13, "message":"...", "details":[]}}
+type HttpServerStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	opts    protojson.MarshalOptions
+	sse     bool
+
+	header      metadata.MD
+	trailer     metadata.MD
+	wroteHeader bool
+}
+
+// NewHttpServerStream create a stream writing to w in the format accepted by req
+func NewHttpServerStream(w http.ResponseWriter, req *http.Request, opts protojson.MarshalOptions) *HttpServerStream {
+	flusher, _ := w.(http.Flusher)
+	return &HttpServerStream{
+		w:       w,
+		flusher: flusher,
+		ctx:     req.Context(),
+		opts:    opts,
+		sse:     acceptsSSE(req),
+	}
+}
+
+func acceptsSSE(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if t, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && t == contentTypeSSE {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeader sets the metadata sent as http headers with the first message
+func (s *HttpServerStream) SetHeader(md metadata.MD) error {
+	if s.wroteHeader {
+		return status.Error(codes.Internal, "headers already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+// SendHeader sends the http headers, it is called implicitly by the first SendMsg
+func (s *HttpServerStream) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.writeHeader()
+	return nil
+}
+
+// SetTrailer is accepted for compatibility, trailers can't be sent over http/1.1 streams and are dropped
+func (s *HttpServerStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func (s *HttpServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg writes one message and flushes it to the client
+func (s *HttpServerStream) SendMsg(m interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message type %T", m)
+	}
+	b, err := s.opts.Marshal(msg)
+	if err != nil {
+		return status.Errorf(codes.Internal, "encode response failed: %v", err)
+	}
+
+	s.writeHeader()
+	if s.sse {
+		err = s.write("data: %s\n\n", b)
+	} else {
+		err = s.write("{\"result\":%s}\n", b)
+	}
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "write response failed: %v", err)
+	}
+	return nil
+}
+
+// RecvMsg is not supported, the request message is decoded from the http request before the method is called
+func (s *HttpServerStream) RecvMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "client streaming is not supported over http")
+}
+
+// Finish ends the stream with the result of the method, the headers are written even if no message is sent,
+// so that an empty stream is still a well-formed response
+func (s *HttpServerStream) Finish(err error) {
+	if err != nil {
+		s.WriteError(err)
+		return
+	}
+	s.writeHeader()
+}
+
+// WriteError ends the stream with err, before any message is sent the error is written as a normal http error response
+func (s *HttpServerStream) WriteError(err error) {
+	if !s.wroteHeader {
+		WriteHttpError(s.w, err)
+		return
+	}
+	b, merr := errorMarshalOptions.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		gLogger.Error("marshal http stream error failed, error: %s", merr.Error())
+		return
+	}
+	if s.sse {
+		s.write("event: error\ndata: %s\n\n", b)
+	} else {
+		s.write("{\"error\":%s}\n", b)
+	}
+}
+
+func (s *HttpServerStream) writeHeader() {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+
+	h := s.w.Header()
+	for k, vs := range s.header {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	if s.sse {
+		h.Set("Content-Type", contentTypeSSE)
+		h.Set("Cache-Control", "no-cache")
+	} else {
+		h.Set("Content-Type", contentTypeNDJSON)
+	}
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *HttpServerStream) write(format string, b []byte) error {
+	if _, err := fmt.Fprintf(s.w, format, b); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// HttpClientStream reads the newline delimited json written by HttpServerStream, it implements grpc.ClientStream
+// so that the generated XxxHttpClient returns the same stream types as the grpc client.
+type HttpClientStream struct {
+	ctx    context.Context
+	resp   *http.Response
+	reader *bufio.Reader
+	opts   protojson.UnmarshalOptions
+	done   func(error) // reports the result to the picker when the stream ends
+	once   sync.Once
+}
+
+// NewHttpClientStream calls the method of the http service by route like HttpInvoke and returns the stream of the response,
+// the stream is bound to ctx instead of the timeout of the client config.
+func NewHttpClientStream(ctx context.Context, serviceName string, route HttpRoute, in proto.Message, mopts protojson.MarshalOptions, uopts protojson.UnmarshalOptions, opts ...grpc.CallOption) (stream *HttpClientStream, err error) {
+	cfg := getClientConfig(serviceName)
+	if cfg == nil {
+		return nil, status.Error(codes.Unavailable, ServiceConfigNotFound.Error())
+	}
+	if cfg.ProtoType != protoTypeHttp {
+		return nil, status.Error(codes.Unavailable, ServiceConfigInvalidProto.Error())
+	}
+
+	uri, body, err := encodeHttpRequest(route, in, mopts)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := callHeaders(ctx, uri, opts)
+	if err != nil {
+		return nil, err
+	}
+	domain, done := cfg.pickEndpoint(ctx)
+	defer func() {
+		if err != nil {
+			done(err)
+		}
+	}()
+	if domain == "" {
+		return nil, status.Errorf(codes.Unavailable, "no available endpoint, service name: %s", serviceName)
+	}
+	if !strings.HasPrefix(domain, "http://") && !strings.HasPrefix(domain, "https://") {
+		domain = "http://" + domain
+	}
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, route.method(), domain+uri, reqBody)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "create http request failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", contentTypeNDJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Errorf(codes.Unavailable, "http request failed, service name: %s, uri: %s, error: %v", serviceName, uri, err)
+	}
+	setCallHeader(opts, resp.Header)
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, decodeHttpError(resp.StatusCode, b)
+	}
+	return &HttpClientStream{
+		ctx:    ctx,
+		resp:   resp,
+		reader: bufio.NewReader(resp.Body),
+		opts:   uopts,
+		done:   done,
+	}, nil
+}
+
+// Header returns the http headers of the response as metadata
+func (s *HttpClientStream) Header() (metadata.MD, error) {
+	return headerMetadata(s.resp.Header), nil
+}
+
+// Trailer is always empty, trailers are not sent over http streams
+func (s *HttpClientStream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+// CloseSend does nothing, the request message is sent when the stream is created
+func (s *HttpClientStream) CloseSend() error {
+	return nil
+}
+
+func (s *HttpClientStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg is not supported, only server streaming methods are served over http
+func (s *HttpClientStream) SendMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "client streaming is not supported over http")
+}
+
+// RecvMsg reads the next message into m, it returns io.EOF at the end of the stream
+// and the status error when the stream ends with an error.
+func (s *HttpClientStream) RecvMsg(m interface{}) error {
+	err := s.recvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			if err == io.EOF {
+				s.done(nil)
+			} else {
+				s.done(err)
+			}
+		})
+	}
+	return err
+}
+
+func (s *HttpClientStream) recvMsg(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message type %T", m)
+	}
+
+	line, err := s.reader.ReadBytes('\n')
+	if len(bytes.TrimSpace(line)) == 0 {
+		if err == nil {
+			return s.recvMsg(m)
+		}
+		s.resp.Body.Close()
+		switch {
+		case err == io.EOF:
+			return io.EOF
+		case s.ctx.Err() != nil:
+			return status.FromContextError(s.ctx.Err()).Err()
+		}
+		return status.Errorf(codes.Unavailable, "read http stream failed: %v", err)
+	}
+
+	var frame struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(line, &frame); err != nil {
+		s.resp.Body.Close()
+		return status.Errorf(codes.Internal, "decode http stream failed: %v", err)
+	}
+	if frame.Error != nil {
+		s.resp.Body.Close()
+		return decodeHttpError(http.StatusInternalServerError, frame.Error)
+	}
+	if err := s.opts.Unmarshal(frame.Result, msg); err != nil {
+		return status.Errorf(codes.Internal, "decode response failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestHttpServerStream(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", contentTypeNDJSON, "{\"result\":{\"name\":\"a\"}}\n{\"error\":{\"code\":14,\"message\":\"gone\",\"details\":[]}}\n"},
+		{"text/event-stream", contentTypeSSE, "data:{\"name\":\"a\"}\n\nevent:error\ndata:{\"code\":14,\"message\":\"gone\",\"details\":[]}\n\n"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/stream", nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+
+		stream := NewHttpServerStream(rec, req, protojson.MarshalOptions{})
+		if err := stream.SendMsg(&descriptorpb.FileDescriptorProto{Name: proto.String("a")}); err != nil {
+			t.Fatal(err)
+		}
+		stream.WriteError(status.Error(codes.Unavailable, "gone"))
+
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("accept %q: content type = %q, want %q", tt.accept, ct, tt.contentType)
+		}
+		// protojson randomly adds spaces to its output, compare without spaces
+		if got := strings.ReplaceAll(rec.Body.String(), " ", ""); got != tt.body {
+			t.Errorf("accept %q: body = %q, want %q", tt.accept, got, tt.body)
+		}
+	}
+}
+
+func TestHttpServerStreamErrorBeforeSend(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := NewHttpServerStream(rec, httptest.NewRequest("GET", "/stream", nil), protojson.MarshalOptions{})
+	stream.WriteError(status.Error(codes.NotFound, "missing"))
+	if rec.Code != 404 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q, want a 404 json error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestHttpServerStreamEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := NewHttpServerStream(rec, httptest.NewRequest("GET", "/stream", nil), protojson.MarshalOptions{})
+	stream.Finish(nil)
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != contentTypeNDJSON || rec.Body.Len() != 0 {
+		t.Errorf("got %d %q %q, want an empty 200 ndjson stream", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
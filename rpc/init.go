@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"net"
 	"os"
 
 	_ "go.uber.org/automaxprocs"
@@ -14,8 +15,8 @@ func init() {
 
 func NewServer(filePath string, opts ...InitOption) (*Server, error) {
 	s := &Server{
-		cfg: initConfig(filePath),
-		Log: defaultLogger(),
+		Log:     defaultLogger(),
+		cfgPath: filePath,
 	}
 
 	for _, opt := range opts {
@@ -24,21 +25,39 @@ func NewServer(filePath string, opts ...InitOption) (*Server, error) {
 
 	setGLogger(s.Log)
 
+	s.cfg, s.Err = initConfig(filePath)
+	if s.Err != nil {
+		return s, s.Err
+	}
+	s.current = s.cfg
+	s.cfgVersion = configVersion(filePath, s.cfg.Env)
+	setLogLevel(s.cfg.Log.Level)
+
+	if s.registry == nil {
+		s.registry, s.Err = newRegistry(s.cfg)
+		if s.Err != nil {
+			return s, s.Err
+		}
+	}
+	globalRegistry = s.registry
+
 	initRpcClient(s)
 
 	initRedisClient(s)
 
 	initDBClient(s)
 
-	s.gs, s.Err = initGrpcServer(s.cfg)
+	s.gs, s.Err = initGrpcServer(s.cfg, s.grpcLis)
 	if s.Err != nil {
 		return s, s.Err
 	}
-	s.hs, s.Err = initHttpServer(s.cfg)
+	s.hs, s.Err = initHttpServer(s.cfg, s.httpLis)
 	if s.Err != nil {
 		return s, s.Err
 	}
 
+	s.initHealth()
+
 	return s, s.Err
 }
 
@@ -52,3 +71,18 @@ func WithLogger(l Logger) InitOption {
 		s.Log = l
 	}}
 }
+
+// WithGrpcListener serves grpc on lis instead of listening on grpc_port,
+// e.g. a listener of 127.0.0.1:0 in tests, the port picked by the system is available by GrpcAddr.
+func WithGrpcListener(lis net.Listener) InitOption {
+	return InitOption{func(s *Server) {
+		s.grpcLis = lis
+	}}
+}
+
+// WithHttpListener serves http on lis instead of listening on http_port.
+func WithHttpListener(lis net.Listener) InitOption {
+	return InitOption{func(s *Server) {
+		s.httpLis = lis
+	}}
+}
@@ -1,22 +1,46 @@
 package rpc
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 var gLogger Logger
 
+const (
+	logLevelInfo int32 = iota
+	logLevelError
+)
+
+var logLevels = map[string]int32{
+	"info":  logLevelInfo,
+	"error": logLevelError,
+}
+
+// logLevel is [log] level of the default logger, it is changed when the config is reloaded
+var logLevel = logLevelInfo
+
+func setLogLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("unknown log level %s", name)
+	}
+	atomic.StoreInt32(&logLevel, level)
+	return nil
+}
+
 type Logger interface {
 	Info(format string, args ...interface{})
 	Error(format string, args ...interface{})
 }
 
-func init()  {
+func init() {
 	setGLogger(defaultLogger())
 }
 
-func setGLogger(l Logger)  {
+func setGLogger(l Logger) {
 	gLogger = l
 }
 
@@ -31,6 +55,9 @@ type myLogger struct {
 }
 
 func (l *myLogger) Info(format string, args ...interface{}) {
+	if atomic.LoadInt32(&logLevel) > logLevelInfo {
+		return
+	}
 	l.log.Printf("[INFO] "+format, args...)
 }
 
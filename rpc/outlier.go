@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	outlierEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "axe_client_outlier_ejections_total",
+		Help: "Total number of the endpoints ejected by the outlier detection of the clients.",
+	}, []string{"service", "endpoint", "reason"})
+	outlierEjected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "axe_client_outlier_ejected_endpoints",
+		Help: "Number of the endpoints currently ejected by the outlier detection of the clients.",
+	}, []string{"service"})
+)
+
+func init() {
+	prometheus.MustRegister(outlierEjections, outlierEjected)
+}
+
+// outlierConfig is [client.outlier] of a [[client]], the endpoints failing continuously are not picked for a while, e.g.
+//
+//	[client.outlier]
+//	enabled = true
+//	consecutive_errors = 5
+type outlierConfig struct {
+	Enabled            bool
+	ConsecutiveErrors  int `toml:"consecutive_errors" default:"5"`     // 连续失败多少次后摘除，0表示不按连续失败摘除
+	FailurePercentage  int `toml:"failure_percentage" default:"0"`     // interval内失败率达到多少(%)后摘除，0表示不按失败率摘除
+	MinRequests        int `toml:"min_requests" default:"20"`          // interval内请求数达到多少后才按失败率摘除
+	Interval           int `toml:"interval" default:"10000"`           // 统计失败率的时间窗口(ms)
+	BaseEjectionTime   int `toml:"base_ejection_time" default:"30000"` // 第一次摘除的时间(ms)，之后每次摘除时间翻倍
+	MaxEjectionTime    int `toml:"max_ejection_time" default:"300000"` // 最长摘除时间(ms)
+	MaxEjectionPercent int `toml:"max_ejection_percent" default:"50"`  // 最多摘除endpoints的百分比，避免全部摘除
+}
+
+// isEndpointFailure reports whether err is caused by the endpoint, e.g. refused connections, timeouts and 5xx,
+// errors of the request like InvalidArgument or NotFound are not failures of the endpoint.
+func isEndpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return true
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+type hostStats struct {
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+
+	ejectedUntil time.Time
+	ejections    int // the ejection time is doubled with each ejection, it decreases after a window without failures
+}
+
+// outlierDetector tracks the results of the endpoints of a client, it is kept when the endpoints change
+type outlierDetector struct {
+	serviceName string
+	cfg         outlierConfig
+	now         func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+	// increased when an endpoint is ejected or comes back, so that the pickers are rebuilt
+	generation uint64
+	nextExpiry time.Time
+}
+
+func newOutlierDetector(serviceName string, cfg outlierConfig) *outlierDetector {
+	return &outlierDetector{serviceName: serviceName, cfg: cfg, now: time.Now, hosts: make(map[string]*hostStats)}
+}
+
+func (d *outlierDetector) host(addr string, now time.Time) *hostStats {
+	h := d.hosts[addr]
+	if h == nil {
+		h = &hostStats{windowStart: now}
+		d.hosts[addr] = h
+	}
+	if interval := time.Duration(d.cfg.Interval) * time.Millisecond; interval > 0 && now.Sub(h.windowStart) >= interval {
+		if h.failures == 0 && h.ejections > 0 && !now.Before(h.ejectedUntil) {
+			h.ejections--
+		}
+		h.requests, h.failures, h.windowStart = 0, 0, now
+	}
+	return h
+}
+
+// report records the result of a request to addr, total is the number of the endpoints of the client
+func (d *outlierDetector) report(addr string, err error, total int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	h := d.host(addr, now)
+	if now.Before(h.ejectedUntil) {
+		// the requests picked before the ejection
+		return
+	}
+	h.requests++
+	if !isEndpointFailure(err) {
+		h.consecutive = 0
+		return
+	}
+	h.failures++
+	h.consecutive++
+
+	reason := ""
+	switch {
+	case d.cfg.ConsecutiveErrors > 0 && h.consecutive >= d.cfg.ConsecutiveErrors:
+		reason = "consecutive_errors"
+	case d.cfg.FailurePercentage > 0 && h.requests >= d.cfg.MinRequests && h.failures*100 >= h.requests*d.cfg.FailurePercentage:
+		reason = "failure_percentage"
+	default:
+		return
+	}
+	if (d.ejectedCount(now)+1)*100 > total*d.cfg.MaxEjectionPercent {
+		return
+	}
+
+	ejection := time.Duration(d.cfg.BaseEjectionTime) * time.Millisecond << uint(h.ejections)
+	if max := time.Duration(d.cfg.MaxEjectionTime) * time.Millisecond; max > 0 && (ejection > max || ejection <= 0) {
+		ejection = max
+	}
+	h.ejections++
+	h.ejectedUntil = now.Add(ejection)
+	h.consecutive, h.requests, h.failures, h.windowStart = 0, 0, 0, now
+	if d.nextExpiry.IsZero() || h.ejectedUntil.Before(d.nextExpiry) {
+		d.nextExpiry = h.ejectedUntil
+	}
+	d.generation++
+
+	gLogger.Error("endpoint %s of %s is ejected for %s, reason: %s", addr, d.serviceName, ejection, reason)
+	outlierEjections.WithLabelValues(d.serviceName, addr, reason).Inc()
+	outlierEjected.WithLabelValues(d.serviceName).Set(float64(d.ejectedCount(now)))
+}
+
+func (d *outlierDetector) ejectedCount(now time.Time) int {
+	n := 0
+	for _, h := range d.hosts {
+		if now.Before(h.ejectedUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+// state returns the generation and the healthy endpoints, the ejections expired come back
+func (d *outlierDetector) state(endpoints []Endpoint) (uint64, []Endpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if !d.nextExpiry.IsZero() && !now.Before(d.nextExpiry) {
+		d.nextExpiry = time.Time{}
+		for _, h := range d.hosts {
+			if now.Before(h.ejectedUntil) && (d.nextExpiry.IsZero() || h.ejectedUntil.Before(d.nextExpiry)) {
+				d.nextExpiry = h.ejectedUntil
+			}
+		}
+		d.generation++
+		outlierEjected.WithLabelValues(d.serviceName).Set(float64(d.ejectedCount(now)))
+	}
+
+	healthy := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if h := d.hosts[ep.Addr]; h == nil || !now.Before(h.ejectedUntil) {
+			healthy = append(healthy, ep)
+		}
+	}
+	return d.generation, healthy
+}
+
+// outlierPicker picks from the endpoints not ejected by the detector
+type outlierPicker struct {
+	balanceType string
+	endpoints   []Endpoint
+	detector    *outlierDetector
+
+	mu         sync.Mutex
+	generation uint64
+	picker     Picker
+}
+
+func newOutlierPicker(balanceType string, endpoints []Endpoint, detector *outlierDetector) (Picker, error) {
+	if _, err := NewPicker(balanceType, endpoints); err != nil {
+		return nil, err
+	}
+	p := &outlierPicker{balanceType: balanceType, endpoints: endpoints, detector: detector}
+	p.current()
+	return p, nil
+}
+
+// current returns the picker of the healthy endpoints, it is rebuilt when the ejected endpoints change
+func (p *outlierPicker) current() Picker {
+	generation, healthy := p.detector.state(p.endpoints)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.picker == nil || generation != p.generation {
+		p.generation = generation
+		picker, err := NewPicker(p.balanceType, healthy)
+		if err != nil {
+			// all the endpoints are ejected
+			picker, _ = NewPicker(p.balanceType, p.endpoints)
+		}
+		p.picker = picker
+	}
+	return p.picker
+}
+
+func (p *outlierPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	ep, done, err := p.current().Pick(ctx)
+	if err != nil {
+		return ep, done, err
+	}
+	total := len(p.endpoints)
+	return ep, func(err error) {
+		done(err)
+		p.detector.report(ep.Addr, err, total)
+	}, nil
+}
+
+// newPicker returns the Picker of the client over endpoints, with the outlier detection when it is enabled
+func (c *clientConfig) newPicker(endpoints []Endpoint) (Picker, error) {
+	if c.outlier != nil {
+		return newOutlierPicker(c.BalanceType, endpoints, c.outlier)
+	}
+	return NewPicker(c.BalanceType, endpoints)
+}
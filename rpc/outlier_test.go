@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newOutlierDetector("outlier_test", outlierConfig{
+		Enabled:            true,
+		ConsecutiveErrors:  3,
+		Interval:           10000,
+		BaseEjectionTime:   1000,
+		MaxEjectionTime:    3000,
+		MaxEjectionPercent: 50,
+	})
+	d.now = func() time.Time { return now }
+	endpoints := []Endpoint{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}}
+	p, _ := newOutlierPicker(BalanceTypeRoundRobin, endpoints, d)
+
+	fail := func(addr string, n int, err error) {
+		for i := 0; i < n; i++ {
+			d.report(addr, err, len(endpoints))
+		}
+	}
+	fail("a", 5, status.Error(codes.NotFound, "not an endpoint failure"))
+	fail("a", 2, errors.New("connection refused"))
+	if _, healthy := d.state(endpoints); len(healthy) != 3 {
+		t.Fatalf("healthy endpoints before ejection = %v", healthy)
+	}
+	fail("a", 1, status.Error(codes.Unavailable, "unavailable"))
+	if counts := pickN(t, p, 30); counts["a"] != 0 || counts["b"] != 15 {
+		t.Fatalf("picks after ejection = %v, want no a", counts)
+	}
+
+	// at most 50% of the endpoints are ejected
+	fail("b", 3, errors.New("timeout"))
+	if counts := pickN(t, p, 30); counts["b"] != 15 {
+		t.Fatalf("picks with max_ejection_percent = %v, want b kept", counts)
+	}
+
+	// a comes back after base_ejection_time, the next ejection is doubled
+	now = now.Add(time.Second)
+	if counts := pickN(t, p, 30); counts["a"] != 10 {
+		t.Fatalf("picks after the ejection expires = %v", counts)
+	}
+	fail("a", 3, errors.New("connection refused"))
+	now = now.Add(time.Second)
+	if counts := pickN(t, p, 30); counts["a"] != 0 {
+		t.Fatalf("picks in the second ejection = %v, want no a", counts)
+	}
+	now = now.Add(time.Second)
+	if counts := pickN(t, p, 30); counts["a"] != 10 {
+		t.Fatalf("picks after the second ejection = %v", counts)
+	}
+
+	// the ejection time is capped by max_ejection_time
+	for i := 0; i < 5; i++ {
+		fail("a", 3, errors.New("connection refused"))
+		now = now.Add(3 * time.Second)
+	}
+	if counts := pickN(t, p, 30); counts["a"] != 10 {
+		t.Fatalf("picks after max_ejection_time = %v", counts)
+	}
+}
+
+func TestOutlierFailurePercentage(t *testing.T) {
+	d := newOutlierDetector("outlier_test", outlierConfig{
+		Enabled:            true,
+		FailurePercentage:  50,
+		MinRequests:        10,
+		Interval:           10000,
+		BaseEjectionTime:   1000,
+		MaxEjectionPercent: 50,
+	})
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%2 == 1 {
+			err = errors.New("connection refused")
+		}
+		d.report("a", err, 2)
+	}
+	if _, healthy := d.state([]Endpoint{{Addr: "a"}, {Addr: "b"}}); len(healthy) != 1 || healthy[0].Addr != "b" {
+		t.Errorf("healthy endpoints = %v, want b", healthy)
+	}
+}
+
+func TestHttpClientOutlier(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer good.Close()
+
+	var cfg Config
+	if _, err := toml.Decode(`
+[[client]]
+service_name = "http_outlier_test"
+proto = "http"
+type = "local"
+endpoints = "`+bad.URL+`,`+good.URL+`"
+timeout = 1000
+retry_times = 1
+per_retry_timeout = 500
+
+[client.outlier]
+enabled = true
+consecutive_errors = 2
+`, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	setDefaultValue(&cfg)
+	c := cfg.RpcClients[0]
+	if c.Outlier.BaseEjectionTime != 30000 || c.Outlier.MaxEjectionPercent != 50 {
+		t.Fatalf("outlier config = %+v, want the defaults", c.Outlier)
+	}
+	c.outlier = newOutlierDetector(c.ServiceName, c.Outlier)
+	if err := c.loadEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	clientConfigMap[c.ServiceName] = &c
+	defer delete(clientConfigMap, c.ServiceName)
+
+	failed := 0
+	for i := 0; i < 20; i++ {
+		if _, err := HttpGet(context.Background(), c.ServiceName, "/", nil); err != nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("failed requests = %d, want 2 before the bad endpoint is ejected", failed)
+	}
+}
@@ -0,0 +1,179 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Endpoint is an address of a client with its weight, e.g. 10.0.0.1:9900;w=3 in endpoints
+type Endpoint struct {
+	Addr   string
+	Weight int
+}
+
+// Picker picks an endpoint for each request, it is built by balance_type of [[client]] and shared by the http and grpc clients.
+// done is called with the result of the request when it finishes.
+type Picker interface {
+	Pick(ctx context.Context) (endpoint Endpoint, done func(err error), err error)
+}
+
+// NewPicker returns the Picker of balanceType over endpoints, an empty balanceType is roundrobin
+func NewPicker(balanceType string, endpoints []Endpoint) (Picker, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoAvailableItem
+	}
+	for i := range endpoints {
+		if endpoints[i].Weight <= 0 {
+			endpoints[i].Weight = 1
+		}
+	}
+	switch balanceType {
+	case BalanceTypeRoundRobin, "":
+		return &roundRobinPicker{endpoints: endpoints, next: uint64(rand.Intn(len(endpoints)))}, nil
+	case BalanceTypeRandom:
+		return &randomPicker{endpoints: endpoints, rand: newRand()}, nil
+	case BalanceTypeWeightedRoundRobin:
+		return newWeightedRoundRobinPicker(endpoints), nil
+	case BalanceTypeLeastRequest:
+		return &leastRequestPicker{outstandingPicker: newOutstandingPicker(endpoints)}, nil
+	case BalanceTypeP2C:
+		return &p2cPicker{outstandingPicker: newOutstandingPicker(endpoints)}, nil
+	case BalanceTypeConsistentHash:
+		return newKetamaPicker(endpoints), nil
+	}
+	return nil, fmt.Errorf("unknown balance type: %s", balanceType)
+}
+
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+func nopDone(error) {}
+
+type roundRobinPicker struct {
+	endpoints []Endpoint
+	next      uint64
+}
+
+func (p *roundRobinPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	n := atomic.AddUint64(&p.next, 1)
+	return p.endpoints[n%uint64(len(p.endpoints))], nopDone, nil
+}
+
+type randomPicker struct {
+	endpoints []Endpoint
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (p *randomPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	p.mu.Lock()
+	n := p.rand.Intn(len(p.endpoints))
+	p.mu.Unlock()
+	return p.endpoints[n], nopDone, nil
+}
+
+// weightedRoundRobinPicker is the smooth weighted round robin of nginx, the picks of an endpoint are spread evenly
+type weightedRoundRobinPicker struct {
+	endpoints []Endpoint
+
+	mu      sync.Mutex
+	current []int
+	total   int
+}
+
+func newWeightedRoundRobinPicker(endpoints []Endpoint) *weightedRoundRobinPicker {
+	p := &weightedRoundRobinPicker{endpoints: endpoints, current: make([]int, len(endpoints))}
+	for _, ep := range endpoints {
+		p.total += ep.Weight
+	}
+	return p
+}
+
+func (p *weightedRoundRobinPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := 0
+	for i, ep := range p.endpoints {
+		p.current[i] += ep.Weight
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= p.total
+	return p.endpoints[best], nopDone, nil
+}
+
+// outstandingPicker counts the requests in flight of each endpoint
+type outstandingPicker struct {
+	endpoints   []Endpoint
+	outstanding []int64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newOutstandingPicker(endpoints []Endpoint) outstandingPicker {
+	return outstandingPicker{endpoints: endpoints, outstanding: make([]int64, len(endpoints)), rand: newRand()}
+}
+
+func (p *outstandingPicker) intn(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Intn(n)
+}
+
+// load is the requests in flight relative to the weight
+func (p *outstandingPicker) load(i int) float64 {
+	return float64(atomic.LoadInt64(&p.outstanding[i])+1) / float64(p.endpoints[i].Weight)
+}
+
+func (p *outstandingPicker) pick(i int) (Endpoint, func(error), error) {
+	atomic.AddInt64(&p.outstanding[i], 1)
+	var once sync.Once
+	return p.endpoints[i], func(error) {
+		once.Do(func() { atomic.AddInt64(&p.outstanding[i], -1) })
+	}, nil
+}
+
+type leastRequestPicker struct {
+	outstandingPicker
+}
+
+// Pick picks the endpoint with the least requests in flight, starting from a random one so that the ties are spread
+func (p *leastRequestPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	n := len(p.endpoints)
+	start := p.intn(n)
+	best := start
+	for i := 1; i < n; i++ {
+		j := (start + i) % n
+		if p.load(j) < p.load(best) {
+			best = j
+		}
+	}
+	return p.pick(best)
+}
+
+// p2cPicker is the power of two choices, it picks two random endpoints and uses the one with less requests in flight
+type p2cPicker struct {
+	outstandingPicker
+}
+
+func (p *p2cPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	n := len(p.endpoints)
+	if n == 1 {
+		return p.pick(0)
+	}
+	a := p.intn(n)
+	b := (a + 1 + p.intn(n-1)) % n
+	if p.load(b) < p.load(a) {
+		a = b
+	}
+	return p.pick(a)
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// the virtual nodes of an endpoint with weight 1, each md5 of a virtual node makes 4 points on the ring like ketama
+const ketamaPointsPerWeight = 160
+
+type hashKeyCtxKey struct{}
+
+// WithHashKey sets the key of the request for balance_type="consistent_hash",
+// the requests with the same key go to the same endpoint as long as it is available, e.g.
+//
+//	ctx = rpc.WithHashKey(ctx, strconv.FormatInt(userID, 10))
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyCtxKey{}, key)
+}
+
+// HashKeyFromContext returns the key set by WithHashKey
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	key, ok := ctx.Value(hashKeyCtxKey{}).(string)
+	return key, ok
+}
+
+type ringPoint struct {
+	hash  uint32
+	index int
+}
+
+// ketamaPicker is the ketama consistent hash, the points of an endpoint only depend on its address,
+// so that only the keys of the added or removed endpoints are remapped when the endpoints change.
+// The requests without a hash key are spread randomly.
+type ketamaPicker struct {
+	endpoints []Endpoint
+	ring      []ringPoint
+
+	random *randomPicker
+}
+
+func newKetamaPicker(endpoints []Endpoint) *ketamaPicker {
+	p := &ketamaPicker{endpoints: endpoints, random: &randomPicker{endpoints: endpoints, rand: newRand()}}
+	for i, ep := range endpoints {
+		for n := 0; n < ketamaPointsPerWeight*ep.Weight/4; n++ {
+			digest := md5.Sum([]byte(ep.Addr + "-" + strconv.Itoa(n)))
+			for j := 0; j < 4; j++ {
+				p.ring = append(p.ring, ringPoint{hash: binary.LittleEndian.Uint32(digest[j*4:]), index: i})
+			}
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		if p.ring[i].hash == p.ring[j].hash {
+			// the same order whatever the order of the endpoints
+			return p.endpoints[p.ring[i].index].Addr < p.endpoints[p.ring[j].index].Addr
+		}
+		return p.ring[i].hash < p.ring[j].hash
+	})
+	return p
+}
+
+func (p *ketamaPicker) Pick(ctx context.Context) (Endpoint, func(error), error) {
+	key, ok := HashKeyFromContext(ctx)
+	if !ok {
+		return p.random.Pick(ctx)
+	}
+	digest := md5.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	if i == len(p.ring) {
+		i = 0
+	}
+	return p.endpoints[p.ring[i].index], nopDone, nil
+}
@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func hashPick(t *testing.T, p Picker, key string) string {
+	ep, _, err := p.Pick(WithHashKey(context.Background(), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ep.Addr
+}
+
+func TestKetamaPicker(t *testing.T) {
+	endpoints := []Endpoint{{Addr: "10.0.0.1:9900"}, {Addr: "10.0.0.2:9900"}, {Addr: "10.0.0.3:9900"}, {Addr: "10.0.0.4:9900"}}
+	p, _ := NewPicker(BalanceTypeConsistentHash, endpoints)
+
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		before[key] = hashPick(t, p, key)
+		counts[before[key]]++
+		if again := hashPick(t, p, key); again != before[key] {
+			t.Fatalf("key %s picked %s then %s", key, before[key], again)
+		}
+	}
+	for addr, n := range counts {
+		if n < 1500 || n > 3500 {
+			t.Errorf("%s got %d of 10000 keys", addr, n)
+		}
+	}
+
+	// remove the last endpoint, only its keys are remapped, the order of the endpoints does not matter
+	p, _ = NewPicker(BalanceTypeConsistentHash, []Endpoint{endpoints[2], endpoints[0], endpoints[1]})
+	for key, addr := range before {
+		after := hashPick(t, p, key)
+		if addr != endpoints[3].Addr && after != addr {
+			t.Fatalf("key %s moved from %s to %s", key, addr, after)
+		}
+	}
+
+	// without a hash key the requests are spread
+	if counts := pickN(t, p, 300); len(counts) != 3 {
+		t.Errorf("picks without hash key = %v", counts)
+	}
+}
+
+func TestDialWithLocalConsistentHash(t *testing.T) {
+	addrs, counts := startCountingServers(t, 2)
+	cfg := &clientConfig{
+		ServiceName: "hash_test",
+		ProtoType:   protoTypeRpc,
+		CallType:    callTypeLocal,
+		BalanceType: BalanceTypeConsistentHash,
+		Endpoints:   addrs[0] + "," + addrs[1],
+		Timeout:     1000,
+	}
+	conn, err := dialWithLocal(context.Background(), cfg, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	check := func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wait until both endpoints are ready
+	for atomic.LoadInt64(counts[0]) == 0 || atomic.LoadInt64(counts[1]) == 0 {
+		check(context.Background())
+	}
+	atomic.StoreInt64(counts[0], 0)
+	atomic.StoreInt64(counts[1], 0)
+
+	ctx := WithHashKey(context.Background(), "user-1")
+	for i := 0; i < 20; i++ {
+		check(ctx)
+	}
+	if a, b := atomic.LoadInt64(counts[0]), atomic.LoadInt64(counts[1]); a*b != 0 || a+b != 20 {
+		t.Errorf("requests with the same hash key = %d, %d, want all to one endpoint", a, b)
+	}
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func pickN(t *testing.T, p Picker, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		ep, done, err := p.Pick(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[ep.Addr]++
+		done(nil)
+	}
+	return counts
+}
+
+func TestPicker(t *testing.T) {
+	endpoints := func() []Endpoint {
+		return []Endpoint{{Addr: "a", Weight: 3}, {Addr: "b"}, {Addr: "c"}}
+	}
+
+	p, _ := NewPicker(BalanceTypeRoundRobin, endpoints())
+	if counts := pickN(t, p, 300); counts["a"] != 100 || counts["b"] != 100 || counts["c"] != 100 {
+		t.Errorf("roundrobin picks = %v", counts)
+	}
+
+	p, _ = NewPicker(BalanceTypeWeightedRoundRobin, endpoints())
+	var seq string
+	for i := 0; i < 5; i++ {
+		ep, _, _ := p.Pick(context.Background())
+		seq += ep.Addr
+	}
+	if seq != "abaca" {
+		t.Errorf("weighted_roundrobin sequence = %s, want abaca", seq)
+	}
+
+	p, _ = NewPicker(BalanceTypeRandom, endpoints())
+	for addr, n := range pickN(t, p, 3000) {
+		if n < 800 || n > 1200 {
+			t.Errorf("random picked %s %d times of 3000", addr, n)
+		}
+	}
+
+	if _, err := NewPicker("unknown", endpoints()); err == nil {
+		t.Errorf("NewPicker with an unknown balance type should fail")
+	}
+	if _, err := NewPicker(BalanceTypeRoundRobin, nil); err == nil {
+		t.Errorf("NewPicker without endpoints should fail")
+	}
+}
+
+func TestOutstandingPicker(t *testing.T) {
+	for _, balanceType := range []string{BalanceTypeLeastRequest, BalanceTypeP2C} {
+		p, _ := NewPicker(balanceType, []Endpoint{{Addr: "a"}, {Addr: "b"}})
+
+		// a is busy with a request in flight, the next requests go to b
+		var busy func(error)
+		for {
+			ep, done, _ := p.Pick(context.Background())
+			if ep.Addr == "a" {
+				busy = done
+				break
+			}
+			done(nil)
+		}
+		if counts := pickN(t, p, 10); counts["b"] != 10 {
+			t.Errorf("%s picks with a busy = %v, want all b", balanceType, counts)
+		}
+
+		busy(nil)
+		busy(nil) // done is idempotent
+		if counts := pickN(t, p, 100); counts["a"] == 0 || counts["b"] == 0 {
+			t.Errorf("%s picks after a is done = %v, want both", balanceType, counts)
+		}
+	}
+}
+
+func TestHttpClientBalance(t *testing.T) {
+	counts := make([]int, 2)
+	var urls []string
+	for i := range counts {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			counts[i]++
+		}))
+		defer ts.Close()
+		urls = append(urls, ts.URL)
+	}
+
+	cfg := &clientConfig{
+		ServiceName:  "http_balance_test",
+		ProtoType:    protoTypeHttp,
+		CallType:     callTypeLocal,
+		BalanceType:  BalanceTypeWeightedRoundRobin,
+		Endpoints:    urls[0] + ";w=3," + urls[1],
+		Timeout:      1000,
+		RetryTimes:   1,
+		RetryTimeout: 500,
+	}
+	if err := cfg.loadEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	clientConfigMap[cfg.ServiceName] = cfg
+	defer delete(clientConfigMap, cfg.ServiceName)
+
+	for i := 0; i < 8; i++ {
+		if _, err := HttpGet(context.Background(), cfg.ServiceName, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Errorf("requests of the endpoints = %v, want [6 2]", counts)
+	}
+}
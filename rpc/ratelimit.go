@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"sync/atomic"
 	"time"
 
 	grpcRateLimit "github.com/grpc-ecosystem/go-grpc-middleware/ratelimit"
@@ -12,13 +13,21 @@ const (
 	LimiterNoBlock    = "no_block"
 )
 
-var limiterMap = map[string]grpcRateLimit.Limiter{
-	LimiterAlwaysPass: &alwaysPassLimiter{},
-	LimiterNoBlock:    &rateLimitNoBlock{},
+var limiterMap = map[string]func(cfg rateLimitConfig) grpcRateLimit.Limiter{
+	LimiterAlwaysPass: func(rateLimitConfig) grpcRateLimit.Limiter {
+		return &alwaysPassLimiter{}
+	},
+	LimiterNoBlock: func(cfg rateLimitConfig) grpcRateLimit.Limiter {
+		return &rateLimitNoBlock{tb: ratelimit.NewBucket(time.Duration(cfg.FillInterval)*time.Millisecond, cfg.Capacity)}
+	},
 }
 
-func name2Limiter(typeName string) grpcRateLimit.Limiter {
-	return limiterMap[typeName]
+func name2Limiter(cfg rateLimitConfig) grpcRateLimit.Limiter {
+	newLimiter, ok := limiterMap[cfg.Type]
+	if !ok {
+		return nil
+	}
+	return newLimiter(cfg)
 }
 
 // alwaysPassLimiter is an example limiter which implements Limiter interface.
@@ -29,24 +38,54 @@ func (*alwaysPassLimiter) Limit() bool {
 	return false
 }
 
-var tb *ratelimit.Bucket
+// serverLimiter is the limiter of the rate limit interceptors, it is replaced when the config is reloaded
+var serverLimiter = &reloadableLimiter{}
+
+type reloadableLimiter struct {
+	v atomic.Value // limiterHolder
+}
+
+// limiterHolder keeps the type stored in atomic.Value the same for all the limiters
+type limiterHolder struct {
+	limiter grpcRateLimit.Limiter
+}
+
+func (l *reloadableLimiter) Limit() bool {
+	h, _ := l.v.Load().(limiterHolder)
+	if h.limiter == nil {
+		return false
+	}
+	return h.limiter.Limit()
+}
+
+func (l *reloadableLimiter) set(limiter grpcRateLimit.Limiter) {
+	l.v.Store(limiterHolder{limiter})
+}
 
 func initRateLimit(cfg *Config) grpcRateLimit.Limiter {
-	if cfg.RateLimit.Type != LimiterAlwaysPass {
-		tb = ratelimit.NewBucket(time.Duration(cfg.RateLimit.FillInterval), cfg.RateLimit.Capacity)
+	applyRateLimit(cfg.RateLimit)
+	return serverLimiter
+}
+
+// applyRateLimit replaces the limiter of the server, the requests are not limited when [rate_limit] is disabled
+func applyRateLimit(cfg rateLimitConfig) {
+	if !cfg.Enabled {
+		serverLimiter.set(nil)
+		return
 	}
-	limiter := name2Limiter(cfg.RateLimit.Type)
-	return limiter
+	serverLimiter.set(name2Limiter(cfg))
 }
 
 // rateLimitNoBlock 如果桶中没有token，不block，直接返回
-type rateLimitNoBlock struct{}
+type rateLimitNoBlock struct {
+	tb *ratelimit.Bucket
+}
 
-func (*rateLimitNoBlock) Limit() bool {
-	if tb == nil {
+func (l *rateLimitNoBlock) Limit() bool {
+	if l.tb == nil {
 		return false
 	}
-	count := tb.TakeAvailable(1)
+	count := l.tb.TakeAvailable(1)
 	if count == 0 {
 		return true
 	} else {
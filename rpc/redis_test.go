@@ -28,13 +28,13 @@ func TestRedisConn(t *testing.T) {
 
 	c, err := GetRedisConn("test")
 	if err != nil {
-		t.Error("get redis conn error: %s\n", err.Error())
+		t.Errorf("get redis conn error: %s", err.Error())
 	}
 	defer c.Close()
 
 	_, err = c.Do("SET", "test", "1")
 	if err != nil {
-		t.Error("redis do error: %s\n", err.Error())
+		t.Errorf("redis do error: %s", err.Error())
 	}
 }
 
@@ -43,6 +43,6 @@ func TestDoRedis(t *testing.T) {
 
 	_, err := DoRedis(context.Background(), "test", "SET", "test", "1")
 	if err != nil {
-		t.Error("get redis conn error: %s\n", err.Error())
+		t.Errorf("get redis conn error: %s", err.Error())
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,205 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// ServiceInstance is an endpoint of a service in the registry
+type ServiceInstance struct {
+	ID      string
+	Name    string
+	Address string // host:port
+	Tags    []string
+	Meta    map[string]string
+}
+
+// Registry registers the endpoints of the server and discovers the endpoints of the services it calls.
+// The registry is selected by [registry] type in the config, or set by WithRegistry.
+type Registry interface {
+	Register(ctx context.Context, ins *ServiceInstance) error
+	Deregister(ctx context.Context, ins *ServiceInstance) error
+	// Watch returns a watcher of the instances of the service named name, it is stopped when ctx is done
+	Watch(ctx context.Context, name string) (Watcher, error)
+}
+
+// Watcher watches the instances of a service
+type Watcher interface {
+	// Next returns the current instances on the first call, then blocks until the instances change
+	Next() ([]*ServiceInstance, error)
+	Stop() error
+}
+
+// healthReporter is implemented by registries keeping the health status of the registered instances, e.g. the consul ttl check
+type healthReporter interface {
+	reportHealth(status, output string)
+}
+
+const (
+	registryTypeConsul = "consul"
+	registryTypeFile   = "file"
+)
+
+// globalRegistry is used by the clients with type="consul" or type="registry" to discover their endpoints
+var globalRegistry Registry
+
+// WithRegistry sets the registry instead of the one of [registry] in the config, e.g. a MemoryRegistry in tests
+func WithRegistry(r Registry) InitOption {
+	return InitOption{func(s *Server) {
+		s.registry = r
+	}}
+}
+
+func newRegistry(cfg *Config) (Registry, error) {
+	switch cfg.Registry.Type {
+	case registryTypeConsul, "":
+		return newConsulRegistry(cfg)
+	case registryTypeFile:
+		return newFileRegistry(cfg.Registry.File, time.Duration(cfg.Registry.RefreshInterval)*time.Millisecond), nil
+	}
+	return nil, fmt.Errorf("unknown registry type: %s", cfg.Registry.Type)
+}
+
+// HttpServiceName is the name of the http endpoints of a service in the registry,
+// the grpc endpoints are registered by the service name, the http endpoints by the name with suffix -http.
+func HttpServiceName(serviceName string) string {
+	return serviceName + "-http"
+}
+
+// the instances registered by the server, ID is the address, e.g. 10.0.0.1-9900,
+// the host is [consul] advertise_address, [server] host, or the local ip when it listens on all addresses
+func (s *Server) serviceInstances() []*ServiceInstance {
+	ip := s.cfg.Consul.AdvertiseAddress
+	if ip == "" {
+		ip = s.cfg.Server.Host
+	}
+	if ip == "" || net.ParseIP(ip).IsUnspecified() {
+		ip = GetLocalIP()
+	}
+	if ip == "" {
+		s.Log.Error("get local ip empty, the server is not registered")
+		return nil
+	}
+
+	instance := func(name string, port int, proto string) *ServiceInstance {
+		meta := map[string]string{"proto": proto}
+		for k, v := range s.cfg.Consul.Meta {
+			meta[k] = v
+		}
+		return &ServiceInstance{
+			ID:      fmt.Sprintf("%s-%d", ip, port),
+			Name:    name,
+			Address: net.JoinHostPort(ip, strconv.Itoa(port)),
+			Tags:    append(append([]string(nil), s.cfg.Consul.Tags...), proto),
+			Meta:    meta,
+		}
+	}
+	var list []*ServiceInstance
+	if !s.gs.None {
+		list = append(list, instance(s.cfg.Server.ServiceName, s.cfg.Server.GrpcPort, protoTypeRpc))
+	}
+	if !s.hs.None {
+		list = append(list, instance(HttpServiceName(s.cfg.Server.ServiceName), s.cfg.Server.HttpPort, protoTypeHttp))
+	}
+	return list
+}
+
+func (s *Server) register() {
+	if s.registry == nil {
+		return
+	}
+	for _, ins := range s.serviceInstances() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err := s.registry.Register(ctx, ins)
+		cancel()
+		if err != nil {
+			s.Log.Error("register %s to registry failed, error: %s", ins.ID, err.Error())
+			continue
+		}
+		s.registered = append(s.registered, ins)
+	}
+	if r, ok := s.registry.(healthReporter); ok {
+		s.health.report = r.reportHealth
+	}
+}
+
+func (s *Server) deregister() {
+	for _, ins := range s.registered {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		if err := s.registry.Deregister(ctx, ins); err != nil {
+			s.Log.Error("deregister %s from registry failed, error: %s", ins.ID, err.Error())
+		} else {
+			s.Log.Info("deregister %s from registry", ins.ID)
+		}
+		cancel()
+	}
+	s.registered = nil
+}
+
+const registryScheme = "axe"
+
+// registryResolverBuilder resolves the grpc target axe:///<service name> by watching the registry
+type registryResolverBuilder struct {
+	registry Registry
+	cfg      *clientConfig
+}
+
+func (b *registryResolverBuilder) Scheme() string {
+	return registryScheme
+}
+
+func (b *registryResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := b.registry.Watch(ctx, target.Endpoint)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &registryResolver{cc: cc, watcher: w, ctx: ctx, cancel: cancel, cfg: b.cfg}
+	go r.watch()
+	return r, nil
+}
+
+type registryResolver struct {
+	cc      resolver.ClientConn
+	watcher Watcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cfg     *clientConfig
+}
+
+func (r *registryResolver) watch() {
+	for {
+		list, err := r.watcher.Next()
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.cc.ReportError(err)
+			select {
+			case <-time.After(time.Second):
+			case <-r.ctx.Done():
+				return
+			}
+			continue
+		}
+		addrs := make([]resolver.Address, 0, len(list))
+		for _, ins := range list {
+			weight, _ := strconv.Atoi(ins.Meta["weight"])
+			addrs = append(addrs, resolver.Address{Addr: ins.Address, ServerName: ins.Name, Attributes: addressAttributes(r.cfg, weight)})
+		}
+		r.cc.UpdateState(resolver.State{Addresses: addrs})
+	}
+}
+
+func (r *registryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *registryResolver) Close() {
+	r.cancel()
+	r.watcher.Stop()
+}
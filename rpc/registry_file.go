@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fileRegistry reads the instances from a toml file, the file is reloaded when it changes, e.g.
+//
+//	[[service]]
+//	name = "user"
+//	endpoints = ["10.0.0.1:9900", "10.0.0.2:9900"]
+//
+// the file is maintained by deployment tools, Register and Deregister do nothing.
+type fileRegistry struct {
+	path     string
+	interval time.Duration
+}
+
+type registryFile struct {
+	Services []struct {
+		Name      string   `toml:"name"`
+		Endpoints []string `toml:"endpoints"`
+	} `toml:"service"`
+}
+
+func newFileRegistry(path string, interval time.Duration) *fileRegistry {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &fileRegistry{path: path, interval: interval}
+}
+
+func (r *fileRegistry) Register(ctx context.Context, ins *ServiceInstance) error {
+	return nil
+}
+
+func (r *fileRegistry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	return nil
+}
+
+func (r *fileRegistry) Watch(ctx context.Context, name string) (Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &fileWatcher{registry: r, name: name, ctx: ctx, cancel: cancel}, nil
+}
+
+func (r *fileRegistry) load(name string) ([]*ServiceInstance, error) {
+	var f registryFile
+	if _, err := toml.DecodeFile(r.path, &f); err != nil {
+		return nil, fmt.Errorf("load registry file %s failed: %v", r.path, err)
+	}
+	var list []*ServiceInstance
+	for _, svc := range f.Services {
+		if svc.Name != name {
+			continue
+		}
+		for _, ep := range svc.Endpoints {
+			list = append(list, &ServiceInstance{ID: ep, Name: name, Address: ep})
+		}
+	}
+	return list, nil
+}
+
+type fileWatcher struct {
+	registry *fileRegistry
+	name     string
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	modTime time.Time
+	last    []*ServiceInstance
+	started bool
+}
+
+func (w *fileWatcher) Next() ([]*ServiceInstance, error) {
+	if !w.started {
+		w.started = true
+		return w.reload()
+	}
+
+	ticker := time.NewTicker(w.registry.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		}
+		fi, err := os.Stat(w.registry.path)
+		if err != nil || fi.ModTime().Equal(w.modTime) {
+			continue
+		}
+		last := w.last
+		list, err := w.reload()
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(list, last) {
+			return list, nil
+		}
+	}
+}
+
+func (w *fileWatcher) reload() ([]*ServiceInstance, error) {
+	fi, err := os.Stat(w.registry.path)
+	if err != nil {
+		return nil, fmt.Errorf("load registry file %s failed: %v", w.registry.path, err)
+	}
+	list, err := w.registry.load(w.name)
+	if err != nil {
+		return nil, err
+	}
+	w.modTime, w.last = fi.ModTime(), list
+	return list, nil
+}
+
+func (w *fileWatcher) Stop() error {
+	w.cancel()
+	return nil
+}
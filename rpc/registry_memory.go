@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryRegistry keeps the instances in memory, servers and clients in the same process share it, e.g. in tests
+type MemoryRegistry struct {
+	mu       sync.Mutex
+	services map[string][]*ServiceInstance
+	watchers map[string]map[*memoryWatcher]struct{}
+}
+
+// NewMemoryRegistry create an empty registry
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: make(map[string][]*ServiceInstance),
+		watchers: make(map[string]map[*memoryWatcher]struct{}),
+	}
+}
+
+// Register adds the instance, or replaces the instance of the same ID
+func (r *MemoryRegistry) Register(ctx context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := r.services[ins.Name]
+	for i, exist := range list {
+		if exist.ID == ins.ID {
+			list[i] = ins
+			r.notify(ins.Name)
+			return nil
+		}
+	}
+	r.services[ins.Name] = append(list, ins)
+	r.notify(ins.Name)
+	return nil
+}
+
+// Deregister removes the instance of the same ID
+func (r *MemoryRegistry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := r.services[ins.Name]
+	for i, exist := range list {
+		if exist.ID == ins.ID {
+			r.services[ins.Name] = append(list[:i:i], list[i+1:]...)
+			r.notify(ins.Name)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Watch(ctx context.Context, name string) (Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &memoryWatcher{
+		registry: r,
+		name:     name,
+		ctx:      ctx,
+		cancel:   cancel,
+		changed:  make(chan struct{}, 1),
+	}
+	w.changed <- struct{}{}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchers[name] == nil {
+		r.watchers[name] = make(map[*memoryWatcher]struct{})
+	}
+	r.watchers[name][w] = struct{}{}
+	return w, nil
+}
+
+func (r *MemoryRegistry) notify(name string) {
+	for w := range r.watchers[name] {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type memoryWatcher struct {
+	registry *MemoryRegistry
+	name     string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	changed  chan struct{}
+}
+
+func (w *memoryWatcher) Next() ([]*ServiceInstance, error) {
+	select {
+	case <-w.changed:
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+	w.registry.mu.Lock()
+	defer w.registry.mu.Unlock()
+	return append([]*ServiceInstance(nil), w.registry.services[w.name]...), nil
+}
+
+func (w *memoryWatcher) Stop() error {
+	w.cancel()
+	w.registry.mu.Lock()
+	defer w.registry.mu.Unlock()
+	delete(w.registry.watchers[w.name], w)
+	return nil
+}
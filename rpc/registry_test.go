@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	r := NewMemoryRegistry()
+	ctx := context.Background()
+	w, err := r.Watch(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if list, err := w.Next(); err != nil || len(list) != 0 {
+		t.Fatalf("first Next = %v, %v, want no instances", list, err)
+	}
+	r.Register(ctx, &ServiceInstance{ID: "a", Name: "user", Address: "10.0.0.1:9900"})
+	r.Register(ctx, &ServiceInstance{ID: "x", Name: "order", Address: "10.0.0.9:9900"})
+	if list, err := w.Next(); err != nil || len(list) != 1 || list[0].Address != "10.0.0.1:9900" {
+		t.Fatalf("Next after register = %v, %v", list, err)
+	}
+	r.Deregister(ctx, &ServiceInstance{ID: "a", Name: "user"})
+	if list, err := w.Next(); err != nil || len(list) != 0 {
+		t.Fatalf("Next after deregister = %v, %v", list, err)
+	}
+
+	w.Stop()
+	if _, err := w.Next(); err == nil {
+		t.Errorf("Next after Stop should fail")
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.toml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+[[service]]
+name = "user"
+endpoints = ["10.0.0.1:9900", "10.0.0.2:9900"]
+`)
+	r := newFileRegistry(path, 10*time.Millisecond)
+	w, _ := r.Watch(context.Background(), "user")
+	defer w.Stop()
+	if list, err := w.Next(); err != nil || len(list) != 2 {
+		t.Fatalf("first Next = %v, %v", list, err)
+	}
+
+	// make sure the modification time changes
+	time.Sleep(20 * time.Millisecond)
+	write(`
+[[service]]
+name = "user"
+endpoints = ["10.0.0.3:9900"]
+`)
+	if list, err := w.Next(); err != nil || len(list) != 1 || list[0].Address != "10.0.0.3:9900" {
+		t.Fatalf("Next after change = %v, %v", list, err)
+	}
+}
+
+func TestDialWithRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	s := newTestServer(t, `
+[server]
+service_name = "registry_test"
+host = "127.0.0.1"
+
+[[client]]
+service_name = "registry_test"
+proto = "rpc"
+type = "registry"
+timeout = 1000
+retry_times = 1
+per_retry_timeout = 500
+`, WithRegistry(registry))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	conn, err := DialService(context.Background(), "registry_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	callCtx, callCancel := context.WithTimeout(context.Background(), time.Second)
+	defer callCancel()
+	hr, err := healthpb.NewHealthClient(conn).Check(callCtx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil || hr.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check through registry = %v, %v", hr, err)
+	}
+
+	cancel()
+	<-result
+	w, _ := registry.Watch(context.Background(), "registry_test")
+	if list, _ := w.Next(); len(list) != 0 {
+		t.Errorf("instances after shutdown = %v, want deregistered", list)
+	}
+}
+
+func TestHttpClientWithRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"name":"ok"}`))
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := &clientConfig{ServiceName: "http_registry_test", ProtoType: protoTypeHttp, CallType: callTypeConsul, Timeout: 1000, RetryTimes: 1, RetryTimeout: 500}
+	clientConfigMap[cfg.ServiceName] = cfg
+	defer delete(clientConfigMap, cfg.ServiceName)
+	if err := cfg.watchEndpoints(ctx, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(descriptorpb.FieldDescriptorProto)
+	err := HttpInvoke(ctx, cfg.ServiceName, HttpRoute{Name: "/pkg.Svc/Get", Pattern: "/pkg.Svc/Get", Body: "*"}, &descriptorpb.FieldDescriptorProto{}, out, protojson.MarshalOptions{}, protojson.UnmarshalOptions{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("HttpInvoke without instances = %v, want Unavailable", err)
+	}
+
+	registry.Register(ctx, &ServiceInstance{ID: "a", Name: HttpServiceName(cfg.ServiceName), Address: strings.TrimPrefix(ts.URL, "http://")})
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if ep, _ := cfg.pickEndpoint(ctx); ep != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = HttpInvoke(ctx, cfg.ServiceName, HttpRoute{Name: "/pkg.Svc/Get", Pattern: "/pkg.Svc/Get", Body: "*"}, &descriptorpb.FieldDescriptorProto{}, out, protojson.MarshalOptions{}, protojson.UnmarshalOptions{})
+	if err != nil || out.GetName() != "ok" {
+		t.Errorf("HttpInvoke with the registered instance = %v, %v", out, err)
+	}
+}
+
+func TestClientRegistryName(t *testing.T) {
+	tests := []struct {
+		cfg  clientConfig
+		want string
+	}{
+		{clientConfig{ServiceName: "a", ProtoType: protoTypeRpc}, "a"},
+		{clientConfig{ServiceName: "a", ProtoType: protoTypeHttp}, "a-http"},
+		{clientConfig{ServiceName: "a", ProtoType: protoTypeHttp, RegistryName: "gateway"}, "gateway"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.registryName(); got != tt.want {
+			t.Errorf("registryName of %s %s = %q, want %q", tt.cfg.ProtoType, tt.cfg.RegistryName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// OnConfigChange registers a callback called after the reloaded config is applied, with the previous and the new config.
+// Callbacks are called in the order they are registered.
+func (s *Server) OnConfigChange(f func(old, new *Config)) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.configHooks = append(s.configHooks, f)
+}
+
+// Reload loads the config file and its overlay again and applies [[client]], [rate_limit] and [log] level, the changes of the other sections
+// take effect after restart. An invalid config is rejected as a whole and the current config is kept.
+// It is called when the file is modified or the process receives SIGHUP while the server is running.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.cfgVersion = configVersion(s.cfgPath, s.current.Env)
+	cfg, err := LoadConfig(s.cfgPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("reload config failed, file path: %s, %s", s.cfgPath, err.Error())
+	}
+	clients, err := newClientConfigs(cfg)
+	if err != nil {
+		return fmt.Errorf("reload config failed, file path: %s, error: %s", s.cfgPath, err.Error())
+	}
+
+	for _, c := range clients {
+		if err := c.start(s.registry); err != nil {
+			s.Log.Error(err.Error())
+		}
+	}
+	setClientConfigs(clients)
+	applyRateLimit(cfg.RateLimit)
+	setLogLevel(cfg.Log.Level)
+
+	old := s.current
+	s.current = cfg
+	for _, name := range restartSections(old, cfg) {
+		s.Log.Error("config [%s] changed, it takes effect after restart", name)
+	}
+	s.Log.Info("config reloaded, file path: %s", s.cfgPath)
+	for _, f := range s.configHooks {
+		f(old, cfg)
+	}
+	return nil
+}
+
+// newClientConfigs builds the clients of the reloaded config, the clients not changed are kept
+func newClientConfigs(cfg *Config) (map[string]*clientConfig, error) {
+	clients := make(map[string]*clientConfig)
+	for _, item := range cfg.RpcClients {
+		c, err := newClientConfig(item, getClientConfig(item.ServiceName))
+		if err != nil {
+			return nil, err
+		}
+		clients[item.ServiceName] = c
+	}
+	return clients, nil
+}
+
+// restartSections returns the changed sections which are not reloadable
+func restartSections(old, cfg *Config) []string {
+	sections := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"server", old.Server, cfg.Server},
+		{"pprof", old.Pprof, cfg.Pprof},
+		{"consul", old.Consul, cfg.Consul},
+		{"registry", old.Registry, cfg.Registry},
+		{"metrics", old.Metrics, cfg.Metrics},
+		{"trace", old.Trace, cfg.Trace},
+		{"docs", old.Docs, cfg.Docs},
+		{"database", old.DBClients, cfg.DBClients},
+		{"redis", old.RedisClients, cfg.RedisClients},
+	}
+	var names []string
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.old, section.new) {
+			names = append(names, section.name)
+		}
+	}
+	return names
+}
+
+// watchConfig reloads the config when the file is modified, checked every reload_interval, or when the process receives SIGHUP
+func (s *Server) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if d := s.cfg.Server.ReloadInterval; d > 0 {
+		ticker := time.NewTicker(time.Duration(d) * time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			s.Log.Info("receive signal SIGHUP, reload config")
+		case <-tick:
+			if !s.configModified() {
+				continue
+			}
+		}
+		if err := s.Reload(); err != nil {
+			s.Log.Error(err.Error())
+		}
+	}
+}
+
+// configModified reports whether the config file or the overlay of the env is modified since the config is loaded last time
+func (s *Server) configModified() bool {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return configVersion(s.cfgPath, s.current.Env) != s.cfgVersion
+}
+
+// configVersion identifies the contents of the config file and the overlay of env, it changes when they are modified
+func configVersion(filePath, env string) string {
+	paths := []string{filePath}
+	if env != "" {
+		paths = append(paths, overlayPath(filePath, env))
+	}
+	var b strings.Builder
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%d-%d;", info.ModTime().UnixNano(), info.Size())
+		} else {
+			b.WriteString("-;")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const reloadTestConfig = `
+[server]
+service_name = "test"
+reload_interval = 50
+
+[[client]]
+service_name = "reload_test"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8001"
+timeout = 1000
+
+[[client]]
+service_name = "reload_kept"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8003"
+timeout = 1000
+`
+
+// writeConfig rewrites the config file with a later modification time, so that the change is seen by the polling
+func writeConfig(t *testing.T, path, config string) {
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := newTestServer(t, reloadTestConfig)
+	defer func() {
+		setLogLevel("info")
+		applyRateLimit(rateLimitConfig{})
+	}()
+	changed := make(chan *Config, 1)
+	s.OnConfigChange(func(old, new *Config) {
+		changed <- new
+	})
+	kept := getClientConfig("reload_kept")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-result
+	}()
+
+	// an invalid config is rejected as a whole
+	if err := ioutil.WriteFile(s.cfgPath, []byte(reloadTestConfig+"\n[log]\nlevel = \"verbose\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Fatal("Reload with an unknown log level succeeded")
+	}
+	if list := getClientConfig("reload_test").EndpointStrList; !reflect.DeepEqual(list, []string{"127.0.0.1:8001"}) {
+		t.Errorf("endpoints after the rejected reload = %v", list)
+	}
+
+	writeConfig(t, s.cfgPath, `
+[server]
+service_name = "test"
+reload_interval = 50
+
+[log]
+level = "error"
+
+[rate_limit]
+enabled = true
+type = "no_block"
+fill_interval = 60000
+capacity = 1
+
+[[client]]
+service_name = "reload_test"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8001,127.0.0.1:8002"
+timeout = 1000
+
+[[client]]
+service_name = "reload_kept"
+proto = "http"
+type = "local"
+endpoints = "127.0.0.1:8003"
+timeout = 1000
+`)
+	select {
+	case cfg := <-changed:
+		if cfg.Log.Level != "error" {
+			t.Errorf("level of the changed config = %s", cfg.Log.Level)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("config not reloaded after the file is modified")
+	}
+
+	if list := getClientConfig("reload_test").EndpointStrList; !reflect.DeepEqual(list, []string{"127.0.0.1:8001", "127.0.0.1:8002"}) {
+		t.Errorf("endpoints after reload = %v", list)
+	}
+	if getClientConfig("reload_kept") != kept {
+		t.Errorf("the client not changed is rebuilt")
+	}
+	if atomic.LoadInt32(&logLevel) != logLevelError {
+		t.Errorf("log level is not changed")
+	}
+	if serverLimiter.Limit() || !serverLimiter.Limit() {
+		t.Errorf("rate limit is not changed, want 1 request passed")
+	}
+	// fill_interval is in milliseconds, one token every minute
+	h, _ := serverLimiter.v.Load().(limiterHolder)
+	if l, ok := h.limiter.(*rateLimitNoBlock); !ok || l.tb.Rate() != 1.0/60 {
+		t.Errorf("rate limit after reload = %+v, want 1 token per 60s", h.limiter)
+	}
+}
+
+func TestReloadWhileWatching(t *testing.T) {
+	registry := NewMemoryRegistry()
+	s := newTestServer(t, `
+[server]
+service_name = "test"
+
+[[client]]
+service_name = "reload_watched"
+proto = "http"
+type = "registry"
+timeout = 1000
+`, WithRegistry(registry))
+	defer setClientConfigs(map[string]*clientConfig{})
+	watched := getClientConfig("reload_watched")
+
+	// the watcher updates the endpoints while the unchanged client is compared by Reload, run it with -race
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ins := &ServiceInstance{ID: "a", Name: HttpServiceName("reload_watched"), Address: "127.0.0.1:8001"}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			registry.Register(context.Background(), ins)
+			registry.Deregister(context.Background(), ins)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := s.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+
+	if c := getClientConfig("reload_watched"); c != watched {
+		t.Errorf("the unchanged client is rebuilt by Reload")
+	}
+}
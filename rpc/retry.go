@@ -0,0 +1,345 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryConfig is [client.retry] of a [[client]], retry_times of the client is the max attempts including the first one, e.g.
+//
+//	[client.retry]
+//	codes = ["UNAVAILABLE", "RESOURCE_EXHAUSTED"]
+//	idempotent_methods = ["/EchoService/Echo"]
+type retryConfig struct {
+	BackoffBase        int      `toml:"backoff_base" default:"50"`                                // 第一次重试前等待的时间(ms)，之后每次翻倍
+	BackoffMax         int      `toml:"backoff_max" default:"1000"`                               // 重试前最长等待的时间(ms)
+	Jitter             float64  `toml:"jitter" default:"0.2"`                                     // 等待时间随机浮动的比例
+	Codes              []string `toml:"codes" default:"[\"UNAVAILABLE\",\"RESOURCE_EXHAUSTED\"]"` // 重试的错误码，连接失败和超时是UNAVAILABLE
+	IdempotentMethods  []string `toml:"idempotent_methods"`                                       // 幂等的grpc方法或http路径，只有幂等的请求才重试
+	RetryNonIdempotent bool     `toml:"retry_non_idempotent"`                                     // 非幂等的请求也重试
+	BudgetTokens       float64  `toml:"budget_tokens" default:"10"`                               // 重试预算，每次重试消耗一个token，0表示不限制
+	BudgetRatio        float64  `toml:"budget_ratio" default:"0.1"`                               // 每个请求增加的token，即重试最多占请求的比例
+}
+
+var defaultRetryCodes = []codes.Code{codes.Unavailable, codes.ResourceExhausted}
+
+// the http methods idempotent by rfc 7231
+var idempotentHttpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// retryLimits are retry_times and per_retry_timeout of a [[client]] or a [[client.method]]
+type retryLimits struct {
+	maxAttempts   int
+	perTryTimeout time.Duration
+}
+
+// retryPolicy is shared by the http client and the grpc interceptor of a [[client]]
+type retryPolicy struct {
+	retryLimits
+	methods map[string]retryLimits
+
+	cfg        retryConfig
+	codes      map[codes.Code]bool
+	idempotent map[string]bool
+	budget     *retryBudget
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newRetryPolicy(c *clientConfig) (*retryPolicy, error) {
+	p := &retryPolicy{
+		retryLimits: newRetryLimits(c.RetryTimes, c.RetryTimeout),
+		methods:     make(map[string]retryLimits),
+		cfg:         c.Retry,
+		codes:       make(map[codes.Code]bool),
+		idempotent:  make(map[string]bool),
+		rand:        newRand(),
+	}
+	for _, m := range c.Methods {
+		limits := p.retryLimits
+		if m.RetryTimes > 0 {
+			limits.maxAttempts = int(m.RetryTimes)
+		}
+		if m.RetryTimeout > 0 {
+			limits.perTryTimeout = time.Duration(m.RetryTimeout) * time.Millisecond
+		}
+		p.methods[m.Name] = limits
+	}
+	for _, name := range c.Retry.Codes {
+		code, err := parseCode(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry code %s of %s", name, c.ServiceName)
+		}
+		p.codes[code] = true
+	}
+	if len(p.codes) == 0 {
+		for _, code := range defaultRetryCodes {
+			p.codes[code] = true
+		}
+	}
+	for _, method := range c.Retry.IdempotentMethods {
+		p.idempotent[method] = true
+	}
+	if c.Retry.BudgetTokens > 0 {
+		p.budget = &retryBudget{max: c.Retry.BudgetTokens, ratio: c.Retry.BudgetRatio, tokens: c.Retry.BudgetTokens}
+	}
+	return p, nil
+}
+
+// parseCode parses the name of a grpc code, e.g. UNAVAILABLE, case insensitive
+func parseCode(name string) (codes.Code, error) {
+	var code codes.Code
+	err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name))))
+	return code, err
+}
+
+func newRetryLimits(retryTimes uint, retryTimeout int) retryLimits {
+	limits := retryLimits{maxAttempts: int(retryTimes), perTryTimeout: time.Duration(retryTimeout) * time.Millisecond}
+	// at least one attempt whatever retry_times is
+	if limits.maxAttempts < 1 {
+		limits.maxAttempts = 1
+	}
+	return limits
+}
+
+// limits returns the limits of [[client.method]] named method, or the limits of the client
+func (p *retryPolicy) limits(method string) retryLimits {
+	if limits, ok := p.methods[method]; ok {
+		return limits
+	}
+	return p.retryLimits
+}
+
+// isIdempotent reports whether the requests of method can be retried, httpMethod is empty for grpc calls
+func (p *retryPolicy) isIdempotent(method, httpMethod string) bool {
+	return p.cfg.RetryNonIdempotent || p.idempotent[method] || idempotentHttpMethods[httpMethod]
+}
+
+// shouldRetry reports whether the attempt failed with err is retried, it takes a token from the budget.
+// The timeouts of the attempts are retried as UNAVAILABLE.
+func (p *retryPolicy) shouldRetry(ctx context.Context, limits retryLimits, attempt int, idempotent bool, err error, timedOut bool) bool {
+	if err == nil || err == ErrCircuitOpen || !idempotent || attempt+1 >= limits.maxAttempts || ctx.Err() != nil {
+		return false
+	}
+	code := codes.Unavailable // connection failures of the http client
+	if st, ok := status.FromError(err); ok && !timedOut {
+		code = st.Code()
+	}
+	if !p.codes[code] {
+		return false
+	}
+	return p.budget.withdraw()
+}
+
+// backoff is the exponential backoff with jitter before the retry after attempt
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := time.Duration(p.cfg.BackoffBase) * time.Millisecond << uint(attempt)
+	if max := time.Duration(p.cfg.BackoffMax) * time.Millisecond; max > 0 && (d > max || d < 0) {
+		d = max
+	}
+	if p.cfg.Jitter > 0 && d > 0 {
+		p.mu.Lock()
+		f := 1 + p.cfg.Jitter*(2*p.rand.Float64()-1)
+		p.mu.Unlock()
+		d = time.Duration(float64(d) * f)
+	}
+	return d
+}
+
+func (p *retryPolicy) wait(ctx context.Context, attempt int) error {
+	d := p.backoff(attempt)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do calls f until it succeeds or the error is not retried, the context of each attempt has per_retry_timeout
+func (p *retryPolicy) do(ctx context.Context, method string, idempotent bool, f func(ctx context.Context) error) error {
+	p.budget.deposit()
+	limits := p.limits(method)
+	for attempt := 0; ; attempt++ {
+		timedOut, err := limits.attempt(ctx, f)
+		if !p.shouldRetry(ctx, limits, attempt, idempotent, err, timedOut) {
+			return err
+		}
+		if p.wait(ctx, attempt) != nil {
+			return err
+		}
+	}
+}
+
+// attempt calls f with per_retry_timeout, timedOut is true when the attempt timeout rather than the call timeout is reached
+func (l retryLimits) attempt(ctx context.Context, f func(ctx context.Context) error) (timedOut bool, err error) {
+	if l.perTryTimeout <= 0 {
+		return false, f(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, l.perTryTimeout)
+	defer cancel()
+	err = f(tctx)
+	return err != nil && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil, err
+}
+
+// retryUnaryClientInterceptor retries the failed unary calls by the retry policy of the client
+func retryUnaryClientInterceptor(p *retryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return p.do(ctx, method, p.isIdempotent(method, ""), func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+// retryStreamClientInterceptor retries the server streams of the idempotent methods by the retry policy of the client, like the unary calls.
+// A stream is retried until the first message is received, the messages received can't be taken back after it.
+// per_retry_timeout is not applied, it would end the streams which run longer.
+func retryStreamClientInterceptor(p *retryPolicy) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if desc.ClientStreams || !p.isIdempotent(method, "") {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		p.budget.deposit()
+		s := &retryingClientStream{
+			ctx:    ctx,
+			policy: p,
+			limits: p.limits(method),
+			newStream: func() (grpc.ClientStream, error) {
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+		}
+		if err := s.retry(nil); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// retryingClientStream opens the stream again when it fails before any message is received, the messages sent are sent again
+type retryingClientStream struct {
+	grpc.ClientStream
+	ctx       context.Context
+	policy    *retryPolicy
+	limits    retryLimits
+	newStream func() (grpc.ClientStream, error)
+
+	attempt   int
+	sent      []interface{}
+	closeSent bool
+	received  bool
+}
+
+func (s *retryingClientStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *retryingClientStream) CloseSend() error {
+	s.closeSent = true
+	return s.ClientStream.CloseSend()
+}
+
+func (s *retryingClientStream) RecvMsg(m interface{}) error {
+	for {
+		err := s.ClientStream.RecvMsg(m)
+		if err == nil {
+			s.received = true
+			return nil
+		}
+		if err == io.EOF || s.received {
+			return err
+		}
+		if err = s.retry(err); err != nil {
+			return err
+		}
+	}
+}
+
+// retry opens the stream again after it failed with err until it is opened or the error is not retried, err is nil for the first attempt
+func (s *retryingClientStream) retry(err error) error {
+	for {
+		if err != nil {
+			if !s.policy.shouldRetry(s.ctx, s.limits, s.attempt, true, err, false) || s.policy.wait(s.ctx, s.attempt) != nil {
+				return err
+			}
+			s.attempt++
+		}
+		if err = s.open(); err == nil {
+			return nil
+		}
+	}
+}
+
+func (s *retryingClientStream) open() error {
+	stream, err := s.newStream()
+	if err != nil {
+		return err
+	}
+	s.ClientStream = stream
+	for _, m := range s.sent {
+		if err := stream.SendMsg(m); err != nil {
+			// the stream is ended, its error is returned by RecvMsg
+			return nil
+		}
+	}
+	if s.closeSent {
+		stream.CloseSend()
+	}
+	return nil
+}
+
+// retryBudget is a token bucket limiting the retries to a ratio of the requests, so that retries do not overload a failing service.
+// A nil budget is unlimited.
+type retryBudget struct {
+	mu     sync.Mutex
+	max    float64
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
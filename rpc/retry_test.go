@@ -0,0 +1,231 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHttpClientRetry(t *testing.T) {
+	var requests int32
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		b, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(b))
+		switch req.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	newClient := func(retryTimes uint, retry retryConfig) *clientConfig {
+		cfg := &clientConfig{
+			ServiceName:     "http_retry_test",
+			ProtoType:       protoTypeHttp,
+			Timeout:         1000,
+			RetryTimes:      retryTimes,
+			RetryTimeout:    500,
+			Retry:           retry,
+			EndpointStrList: []string{ts.URL},
+		}
+		clientConfigMap[cfg.ServiceName] = cfg
+		return cfg
+	}
+	defer delete(clientConfigMap, "http_retry_test")
+	ctx := context.Background()
+
+	cases := []struct {
+		name       string
+		retryTimes uint
+		retry      retryConfig
+		method     string
+		uri        string
+		requests   int32
+	}{
+		{"retry_times=0 sends the request", 0, retryConfig{}, "GET", "/", 1},
+		{"GET is retried", 3, retryConfig{}, "GET", "/unavailable", 3},
+		{"4xx is not retried", 3, retryConfig{}, "GET", "/bad", 1},
+		{"POST is not retried", 3, retryConfig{}, "POST", "/unavailable", 1},
+		{"idempotent POST is retried", 3, retryConfig{IdempotentMethods: []string{"/unavailable"}}, "POST", "/unavailable", 3},
+		{"retry_non_idempotent", 2, retryConfig{RetryNonIdempotent: true}, "POST", "/unavailable", 2},
+		{"the codes are configured", 3, retryConfig{Codes: []string{"invalid_argument"}}, "GET", "/bad", 3},
+	}
+	for _, c := range cases {
+		atomic.StoreInt32(&requests, 0)
+		bodies = nil
+		newClient(c.retryTimes, c.retry)
+		if c.method == "GET" {
+			HttpGet(ctx, "http_retry_test", c.uri, nil)
+		} else {
+			HttpPost(ctx, "http_retry_test", c.uri, nil, bytes.NewReader([]byte(`{"id":1}`)))
+		}
+		if n := atomic.LoadInt32(&requests); n != c.requests {
+			t.Errorf("%s: %d requests, want %d", c.name, n, c.requests)
+		}
+		if c.method == "POST" {
+			for _, b := range bodies {
+				if b != `{"id":1}` {
+					t.Errorf("%s: body of the retry = %q", c.name, b)
+				}
+			}
+		}
+	}
+
+	// the budget of 1 token allows one retry
+	atomic.StoreInt32(&requests, 0)
+	cfg := newClient(3, retryConfig{BudgetTokens: 1})
+	cfg.retry, _ = newRetryPolicy(cfg)
+	HttpGet(ctx, "http_retry_test", "/unavailable", nil)
+	HttpGet(ctx, "http_retry_test", "/unavailable", nil)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("requests with a retry budget of 1 = %d, want 3", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p, err := newRetryPolicy(&clientConfig{RetryTimes: 5, Retry: retryConfig{BackoffBase: 50, BackoffMax: 300, Jitter: 0.2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempt, want := range []time.Duration{50, 100, 200, 300, 300} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d < want*8/10 || d > want*12/10 {
+				t.Fatalf("backoff(%d) = %s, want %s±20%%", attempt, d, want)
+			}
+		}
+	}
+
+	if _, err := newRetryPolicy(&clientConfig{Retry: retryConfig{Codes: []string{"NOT_A_CODE"}}}); err == nil {
+		t.Errorf("newRetryPolicy with an invalid code should fail")
+	}
+}
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	p, _ := newRetryPolicy(&clientConfig{RetryTimes: 3, RetryTimeout: 50, Retry: retryConfig{IdempotentMethods: []string{"/pkg.Svc/Get"}}})
+	interceptor := retryUnaryClientInterceptor(p)
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls == 1 {
+			// the attempt timeout is retried
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+	if err := interceptor(context.Background(), "/pkg.Svc/Get", nil, nil, nil, invoker); err != nil || calls != 3 {
+		t.Errorf("idempotent call = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	if err := interceptor(context.Background(), "/pkg.Svc/Create", nil, nil, nil, invoker); status.Code(err) != codes.DeadlineExceeded || calls != 1 {
+		t.Errorf("non-idempotent call = %v after %d calls, want no retry", err, calls)
+	}
+}
+
+// fakeClientStream receives the messages of recv, then ends with err
+type fakeClientStream struct {
+	grpc.ClientStream
+	sent   []interface{}
+	closed bool
+	recv   int
+	err    error
+}
+
+func (s *fakeClientStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *fakeClientStream) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	if s.recv > 0 {
+		s.recv--
+		return nil
+	}
+	return s.err
+}
+
+func TestRetryStreamClientInterceptor(t *testing.T) {
+	p, _ := newRetryPolicy(&clientConfig{RetryTimes: 3, Retry: retryConfig{IdempotentMethods: []string{"/pkg.Svc/Watch"}, BudgetTokens: 2}})
+	interceptor := retryStreamClientInterceptor(p)
+	desc := &grpc.StreamDesc{ServerStreams: true}
+
+	var streams []*fakeClientStream
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		// the first stream fails before any message, the next one receives a message and then fails
+		s := &fakeClientStream{err: status.Error(codes.Unavailable, "unavailable")}
+		if len(streams) > 0 {
+			s.recv = 1
+		}
+		streams = append(streams, s)
+		return s, nil
+	}
+	recv := func(method string) (int, error) {
+		stream, err := interceptor(context.Background(), desc, nil, method, streamer)
+		if err != nil {
+			return 0, err
+		}
+		stream.SendMsg("req")
+		stream.CloseSend()
+		var n int
+		for {
+			if err := stream.RecvMsg(nil); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	n, err := recv("/pkg.Svc/Watch")
+	if n != 1 || status.Code(err) != codes.Unavailable || len(streams) != 2 {
+		t.Errorf("idempotent stream received %d messages from %d streams, %v, want 1 from 2 streams", n, len(streams), err)
+	}
+	if len(streams[1].sent) != 1 || !streams[1].closed {
+		t.Errorf("the retried stream is not sent the request again")
+	}
+
+	streams = nil
+	if n, err := recv("/pkg.Svc/Create"); n != 0 || status.Code(err) != codes.Unavailable || len(streams) != 1 {
+		t.Errorf("non-idempotent stream = %d, %v from %d streams, want no retry", n, err, len(streams))
+	}
+
+	// the budget has one token left after the first retry
+	streams = nil
+	recv("/pkg.Svc/Watch")
+	streams = nil
+	if _, err := recv("/pkg.Svc/Watch"); status.Code(err) != codes.Unavailable || len(streams) != 1 {
+		t.Errorf("stream retried %d times with the budget used up", len(streams)-1)
+	}
+}
+
+func TestRetryConfigDefaults(t *testing.T) {
+	cfg := Config{RpcClients: []clientConfig{{ServiceName: "a"}}}
+	setDefaultValue(&cfg)
+	retry := cfg.RpcClients[0].Retry
+	if len(retry.Codes) != 2 || retry.Codes[0] != "UNAVAILABLE" || retry.BackoffBase != 50 || retry.BudgetTokens != 10 {
+		t.Errorf("retry config = %+v, want the defaults", retry)
+	}
+}
@@ -1,10 +1,12 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"sync"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-middleware/ratelimit"
@@ -14,20 +16,44 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
+// defaultMetricsOnce registers /metrics on DefaultServeMux for the first server, another one would panic
+var defaultMetricsOnce sync.Once
+
 type Server struct {
 	cfg *Config
 	hs  *httpServer
 	gs  *grpcServer
 	Log Logger
 	Err error
+
+	grpcLis net.Listener // set by WithGrpcListener
+	httpLis net.Listener // set by WithHttpListener
+	pprof   *http.Server
+	health  *healthChecker
+
+	registry   Registry
+	registered []*ServiceInstance
+	serveErr   chan error
+
+	shutdownHooks []func()
+	shutdownOnce  sync.Once
+	shutdownErr   error
+
+	cfgPath     string
+	cfgVersion  string // the version of the files when the config is loaded last time, see configVersion
+	reloadMu    sync.Mutex
+	current     *Config // the config applied last, s.cfg is the config at startup
+	configHooks []func(old, new *Config)
 }
 
 type httpServer struct {
 	s    *http.Server
+	mux  *HttpMux
 	lis  net.Listener
 	addr string
 	None bool // 标记没有设置port，不想启动http服务时的情况
@@ -40,21 +66,36 @@ type grpcServer struct {
 	None bool // 标记没有设置port，不想启动grpc服务时的情况
 }
 
+// GrpcAddr returns the listening address of the grpc server, with the actual port when it listens on port 0
 func (s *Server) GrpcAddr() string {
+	if s.gs != nil && s.gs.lis != nil {
+		return s.gs.lis.Addr().String()
+	}
 	return fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.GrpcPort)
 }
 
+// HttpAddr returns the listening address of the http server, with the actual port when it listens on port 0
 func (s *Server) HttpAddr() string {
+	if s.hs != nil && s.hs.lis != nil {
+		return s.hs.lis.Addr().String()
+	}
 	return fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.HttpPort)
 }
 
-func initHttpServer(cfg *Config) (*httpServer, error) {
+func initHttpServer(cfg *Config, lis net.Listener) (*httpServer, error) {
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.HttpPort)
+	mux := NewHttpMux()
 	hs := &httpServer{
 		addr: addr,
-		s:    &http.Server{},
+		s:    &http.Server{Handler: mux},
+		mux:  mux,
 	}
 
+	if lis != nil {
+		hs.addr, hs.lis = lis.Addr().String(), lis
+		cfg.Server.HttpPort = listenerPort(lis)
+		return hs, nil
+	}
 	if cfg.Server.HttpPort == 0 {
 		hs.None = true
 		return hs, nil
@@ -70,12 +111,17 @@ func initHttpServer(cfg *Config) (*httpServer, error) {
 	return hs, nil
 }
 
-func initGrpcServer(cfg *Config) (*grpcServer, error) {
+func initGrpcServer(cfg *Config, lis net.Listener) (*grpcServer, error) {
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.GrpcPort)
 	gs := &grpcServer{
 		addr: addr,
 		s:    grpc.NewServer(makeMiddlewareInterceptor(cfg)...),
 	}
+	if lis != nil {
+		gs.addr, gs.lis = lis.Addr().String(), lis
+		cfg.Server.GrpcPort = listenerPort(lis)
+		return gs, nil
+	}
 	if cfg.Server.GrpcPort == 0 {
 		gs.None = true
 		return gs, nil
@@ -91,6 +137,14 @@ func initGrpcServer(cfg *Config) (*grpcServer, error) {
 	return gs, nil
 }
 
+// listenerPort returns the port of a tcp listener, e.g. the port picked by the system for port 0
+func listenerPort(lis net.Listener) int {
+	if addr, ok := lis.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
 // makeMiddlewareInterceptor Sending unary almost always faster. Use streaming to send big files.
 func makeMiddlewareInterceptor(cfg *Config) []grpc.ServerOption {
 	var siList []grpc.StreamServerInterceptor
@@ -112,12 +166,10 @@ func makeMiddlewareInterceptor(cfg *Config) []grpc.ServerOption {
 		uiList = append(uiList, grpc_opentracing.UnaryServerInterceptor(opts...))
 	}
 
-	// rate limit
-	if cfg.RateLimit.Enabled {
-		limiter := initRateLimit(cfg)
-		siList = append(siList, ratelimit.StreamServerInterceptor(limiter))
-		uiList = append(uiList, ratelimit.UnaryServerInterceptor(limiter))
-	}
+	// rate limit, the interceptors are always added so that [rate_limit] can be enabled by reloading the config
+	limiter := initRateLimit(cfg)
+	siList = append(siList, ratelimit.StreamServerInterceptor(limiter))
+	uiList = append(uiList, ratelimit.UnaryServerInterceptor(limiter))
 
 	// panic recovery
 	opts := []grpc_recovery.Option{
@@ -142,43 +194,85 @@ func (s *Server) HttpServer() *http.Server {
 	return s.hs.s
 }
 
+// HttpMux returns the router of the http server, the generated RegisterXxxHttpServer functions register into it,
+// so that all services share the http port.
+func (s *Server) HttpMux() *HttpMux {
+	return s.hs.mux
+}
+
+// Serve runs the server until the process receives an exit signal, then shuts it down gracefully,
+// it is Run with the context of SignalContext.
 func (s *Server) Serve(options ...ServeOption) error {
-	if s == nil {
-		return fmt.Errorf("grpc server is nil")
+	ctx, cancel := SignalContext(context.Background())
+	defer cancel()
+	return s.Run(ctx, options...)
+}
+
+// Run starts the grpc and http servers and blocks until ctx is done or one of them fails,
+// then shuts the server down gracefully like Shutdown. It returns the error of the failed server or of the shutdown.
+// While running, the config is reloaded when the file is modified or the process receives SIGHUP, see Reload.
+func (s *Server) Run(ctx context.Context, options ...ServeOption) error {
+	if err := s.start(options...); err != nil {
+		return err
 	}
-	if s.gs.None && s.hs.None {
-		return fmt.Errorf("both grpc and http server are nil")
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		s.watchConfig(watchCtx)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-s.serveErr:
+	}
+	stopWatch()
+	<-watched
+
+	if serr := s.Shutdown(context.Background()); err == nil {
+		err = serr
 	}
+	return err
+}
 
+func (s *Server) start(options ...ServeOption) error {
+	if s == nil {
+		return fmt.Errorf("grpc server is nil")
+	}
 	if s.Err != nil {
 		return s.Err
 	}
+	if s.gs.None && s.hs.None {
+		return fmt.Errorf("both grpc and http server are nil")
+	}
 
 	do := serveOptions{}
 	for _, option := range options {
 		option.f(&do)
 	}
 
-	defer func() {
-		if GlobalTraceCloser != nil {
-			GlobalTraceCloser.Close()
-		}
-	}()
+	s.serveErr = make(chan error, 2)
 
-	// 只有非dev环境，并且开关打开，才执行consul的注册和metrics的监听
-	if s.cfg.Consul.Enabled {
-		if err := registerConsul(s.cfg); err != nil {
-			s.Log.Error("register consul failed, error: %s", err.Error())
-		}
-	}
+	s.register()
 
 	if s.cfg.Metrics.Enabled {
-		http.Handle("/metrics", promhttp.Handler())
+		// served on both the pprof port and the http port, DefaultServeMux is shared by the servers of the process
+		defaultMetricsOnce.Do(func() {
+			http.Handle("/metrics", promhttp.Handler())
+		})
+		s.hs.mux.Handle("GET", "/metrics", promhttp.Handler())
+	}
+
+	if s.cfg.Docs.Enabled && !s.hs.None {
+		handleDocs(s.hs.mux, s.cfg.Server.ServiceName, s.cfg.Docs)
 	}
 
 	if s.cfg.Pprof.Port != 0 {
+		s.pprof = &http.Server{Addr: fmt.Sprintf(":%d", s.cfg.Pprof.Port)}
 		go func() {
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", s.cfg.Pprof.Port), nil); err != nil {
+			if err := s.pprof.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				s.Log.Error("init pprof with port [%d] failed, error: %s", s.cfg.Pprof.Port, err.Error())
 			}
 		}()
@@ -186,12 +280,21 @@ func (s *Server) Serve(options ...ServeOption) error {
 
 	s.serveGrpc()
 	s.serveHttp()
-
-	onExit(onSignal())
-
+	go s.health.run()
 	return nil
 }
 
+// initHealth registers the grpc health service, /healthz and /readyz
+func (s *Server) initHealth() {
+	s.health = newHealthChecker(s.cfg.Server.ServiceName)
+	if !s.gs.None {
+		healthpb.RegisterHealthServer(s.gs.s, s.health.grpc)
+	}
+	if !s.hs.None {
+		handleHealth(s.hs.mux, s.health)
+	}
+}
+
 func (s *Server) serveGrpc() {
 	if s.gs.None {
 		return
@@ -206,6 +309,7 @@ func (s *Server) serveGrpc() {
 		err := s.gs.s.Serve(s.gs.lis)
 		if err != nil {
 			s.Log.Error("grpc server serve failed, error: %s", err.Error())
+			s.serveErr <- err
 		}
 	}()
 }
@@ -216,8 +320,9 @@ func (s *Server) serveHttp() {
 	}
 	go func() {
 		err := s.hs.s.Serve(s.hs.lis)
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			s.Log.Error("http server serve failed, error: %s", err.Error())
+			s.serveErr <- err
 		}
 	}()
 }
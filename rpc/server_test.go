@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newTestServer creates a server listening on ephemeral ports of 127.0.0.1
+func newTestServer(t *testing.T, config string, opts ...InitOption) *Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rpc.toml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(path, append([]InitOption{WithGrpcListener(gl), WithHttpListener(hl)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServerRun(t *testing.T) {
+	s := newTestServer(t, `
+[server]
+service_name = "test"
+shutdown_timeout = 1000
+`)
+	s.HttpMux().HandleFunc("GET", "/ping", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	var hooked bool
+	s.OnShutdown(func() { hooked = true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Run(ctx) }()
+
+	resp, err := http.Get("http://" + s.HttpAddr() + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "pong" {
+		t.Errorf("http response = %q, want pong", b)
+	}
+
+	conn, err := grpc.Dial(s.GrpcAddr(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hr, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || hr.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("grpc health check = %v, %v", hr, err)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run didn't return after ctx is canceled")
+	}
+	if !hooked {
+		t.Errorf("shutdown hook not called")
+	}
+	if _, err := http.Get("http://" + s.HttpAddr() + "/ping"); err == nil {
+		t.Errorf("http server still serving after Run returned")
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown returned %v", err)
+	}
+}
+
+func TestServerRunServeError(t *testing.T) {
+	s := newTestServer(t, `
+[server]
+service_name = "test"
+`)
+	// the http server fails to accept on a closed listener
+	s.hs.lis.Close()
+
+	result := make(chan error, 1)
+	go func() { result <- s.Run(context.Background()) }()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("Run should return the error of the http server")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run didn't return after the http server failed")
+	}
+}
+
+func TestServerNoListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.toml")
+	ioutil.WriteFile(path, []byte("[server]\nservice_name = \"test\"\n"), 0644)
+	s, err := NewServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(context.Background()); err == nil {
+		t.Errorf("Run without grpc_port and http_port should fail")
+	}
+}
+
+func TestServerMetricsTwice(t *testing.T) {
+	config := `
+[server]
+service_name = "test"
+shutdown_timeout = 1000
+
+[metrics]
+enabled = true
+`
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 2)
+	running := 0
+	defer func() {
+		cancel()
+		for ; running > 0; running-- {
+			<-result
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		s := newTestServer(t, config)
+		go func() { result <- s.Run(ctx) }()
+		running++
+
+		resp, err := http.Get("http://" + s.HttpAddr() + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("server %d: /metrics code = %d", i, resp.StatusCode)
+		}
+	}
+}
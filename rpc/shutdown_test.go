@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsInflightRequests(t *testing.T) {
+	// shutdown_timeout 0 uses the default instead of closing the in-flight requests at once
+	for _, timeout := range []int{1000, 0} {
+		t.Run(fmt.Sprintf("timeout %d", timeout), func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				close(started)
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte("done"))
+			})
+			s := &Server{
+				cfg: &Config{Server: serverConfig{ShutdownTimeout: timeout}},
+				Log: defaultLogger(),
+				gs:  &grpcServer{None: true},
+				hs:  &httpServer{s: &http.Server{Handler: handler}, lis: l},
+			}
+			s.health = newHealthChecker("test")
+			var hooked bool
+			s.OnShutdown(func() { hooked = true })
+			s.serveHttp()
+
+			result := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String())
+				if err != nil {
+					result <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := ioutil.ReadAll(resp.Body)
+				result <- string(b)
+			}()
+
+			<-started
+			s.Shutdown(context.Background())
+			if got := <-result; got != "done" {
+				t.Errorf("in-flight request got %q, want done", got)
+			}
+			if !hooked {
+				t.Errorf("shutdown hook not called")
+			}
+			if _, err := http.Get("http://" + l.Addr().String()); err == nil {
+				t.Errorf("server still accepts requests after shutdown")
+			}
+		})
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(2 * time.Second)
+	})
+	s := &Server{
+		cfg: &Config{},
+		Log: defaultLogger(),
+		gs:  &grpcServer{None: true},
+		hs:  &httpServer{s: &http.Server{Handler: handler}, lis: l},
+	}
+	s.health = newHealthChecker("test")
+	s.serveHttp()
+	go http.Get("http://" + l.Addr().String())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	s.drain(ctx)
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("drain took %v, want it to stop at the deadline", d)
+	}
+}
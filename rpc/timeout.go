@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// methodConfig is a [[client.method]] of a [[client]], it overrides the timeouts of the client for a method, e.g.
+//
+//	[[client.method]]
+//	name = "/EchoService/Echo"
+//	timeout = 200
+type methodConfig struct {
+	Name         string `toml:"name"`              // grpc的方法名 /package.Service/Method，或http的路径
+	Timeout      int    `toml:"timeout"`           // 超时时间(ms)，0表示使用client的timeout
+	RetryTimes   uint   `toml:"retry_times"`       // 最多调用的次数，0表示使用client的retry_times
+	RetryTimeout int    `toml:"per_retry_timeout"` // 每次调用的超时(ms)，0表示使用client的per_retry_timeout
+}
+
+// callTimeout is the total timeout of a call of method, including the retries
+func (c *clientConfig) callTimeout(method string) time.Duration {
+	for _, m := range c.Methods {
+		if m.Name == method && m.Timeout > 0 {
+			return time.Duration(m.Timeout) * time.Millisecond
+		}
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+// timeoutUnaryClientInterceptor applies the timeout of the client to the calls whose context has no deadline,
+// the deadline of the caller is kept and propagated to the server by grpc.
+func timeoutUnaryClientInterceptor(c *clientConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			if timeout := c.callTimeout(method); timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
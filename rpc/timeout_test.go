@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTimeoutUnaryClientInterceptor(t *testing.T) {
+	cfg := &clientConfig{
+		ServiceName: "timeout_test",
+		Timeout:     1000,
+		Methods:     []methodConfig{{Name: "/EchoService/Echo", Timeout: 200}},
+	}
+	interceptor := timeoutUnaryClientInterceptor(cfg)
+
+	var remaining time.Duration
+	var hasDeadline bool
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		var deadline time.Time
+		deadline, hasDeadline = ctx.Deadline()
+		remaining = time.Until(deadline)
+		return nil
+	}
+
+	cases := []struct {
+		method string
+		ctx    func() (context.Context, context.CancelFunc)
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"/EchoService/Other", func() (context.Context, context.CancelFunc) { return context.Background(), func() {} }, 900 * time.Millisecond, time.Second},
+		{"/EchoService/Echo", func() (context.Context, context.CancelFunc) { return context.Background(), func() {} }, 100 * time.Millisecond, 200 * time.Millisecond},
+		{"/EchoService/Echo", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 5*time.Second)
+		}, 4 * time.Second, 5 * time.Second},
+	}
+	for _, c := range cases {
+		ctx, cancel := c.ctx()
+		interceptor(ctx, c.method, nil, nil, nil, invoker)
+		cancel()
+		if !hasDeadline || remaining < c.min || remaining > c.max {
+			t.Errorf("deadline of %s = %v, %s, want in [%s, %s]", c.method, hasDeadline, remaining, c.min, c.max)
+		}
+	}
+}
+
+func TestRetryMethodLimits(t *testing.T) {
+	p, _ := newRetryPolicy(&clientConfig{
+		RetryTimes: 3,
+		Retry:      retryConfig{RetryNonIdempotent: true},
+		Methods:    []methodConfig{{Name: "/pkg.Svc/Once", RetryTimes: 1}},
+	})
+	for method, want := range map[string]int{"/pkg.Svc/Get": 3, "/pkg.Svc/Once": 1} {
+		calls := 0
+		p.do(context.Background(), method, true, func(ctx context.Context) error {
+			calls++
+			return status.Error(codes.Unavailable, "unavailable")
+		})
+		if calls != want {
+			t.Errorf("calls of %s = %d, want %d", method, calls, want)
+		}
+	}
+}
+
+func TestHttpClientMethodTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &clientConfig{
+		ServiceName:     "http_timeout_test",
+		ProtoType:       protoTypeHttp,
+		Timeout:         5000,
+		RetryTimes:      1,
+		EndpointStrList: []string{ts.URL},
+		Methods:         []methodConfig{{Name: "/slow", Timeout: 100}},
+	}
+	clientConfigMap[cfg.ServiceName] = cfg
+	defer delete(clientConfigMap, cfg.ServiceName)
+
+	start := time.Now()
+	_, err := HttpGet(context.Background(), cfg.ServiceName, "/slow?id=1", nil)
+	if status.Code(err) != codes.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("HttpGet = %v after %s, want DeadlineExceeded after 100ms", err, time.Since(start))
+	}
+}